@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		replay  string
+		want    []int
+		wantErr bool
+	}{
+		{replay: "", want: nil},
+		{replay: "all", want: []int{1, 2, 3}},
+		{replay: "1, 3", want: []int{1, 3}},
+		{replay: "1,two", wantErr: true},
+	}
+	for _, tt := range tests {
+		selected, err := parseSelection(tt.replay)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSelection(%q) succeeded", tt.replay)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parseSelection(%q): %v", tt.replay, err)
+		}
+		var got []int
+		for n := 1; n <= 3; n++ {
+			if selected(n) {
+				got = append(got, n)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelection(%q) selects %v, want %v", tt.replay, got, tt.want)
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	death := func(exchange string, keys ...any) pubsub.Table {
+		return pubsub.Table{"exchange": exchange, "routing-keys": keys, "queue": "q", "reason": "rejected"}
+	}
+	tests := []struct {
+		name     string
+		headers  pubsub.Table
+		exchange string
+		key      string
+		ok       bool
+	}{
+		{name: "not dead-lettered", headers: pubsub.Table{}},
+		{
+			name:     "rejected",
+			headers:  pubsub.Table{"x-death": []any{death("peril_topic", "game_logs.alice")}},
+			exchange: "peril_topic", key: "game_logs.alice", ok: true,
+		},
+		{
+			name: "first death is the oldest",
+			headers: pubsub.Table{"x-death": []any{
+				death("", "game_logs"),
+				death("peril_topic", "game_logs.alice"),
+			}},
+			exchange: "peril_topic", key: "game_logs.alice", ok: true,
+		},
+		{
+			name: "retried",
+			headers: pubsub.Table{
+				"x-death":                []any{death("", "game_logs.retry.500ms")},
+				"x-retry-attempt":        int64(2),
+				"x-original-exchange":    "peril_topic",
+				"x-original-routing-key": "game_logs.alice",
+			},
+			exchange: "peril_topic", key: "game_logs.alice", ok: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := pubsub.RawDelivery{Message: pubsub.Message{Headers: tt.headers}}
+			exchange, key, ok := origin(d)
+			if exchange != tt.exchange || key != tt.key || ok != tt.ok {
+				t.Fatalf("origin = %q, %q, %v; want %q, %q, %v", exchange, key, ok, tt.exchange, tt.key, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRunReplaysSelected(t *testing.T) {
+	b := pubsub.NewMemoryServer().Connect()
+	defer b.Close()
+	if err := routing.DeclareTopology(b); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob"} {
+		gl := routing.GameLog{CurrentTime: time.Now(), Username: user, Message: "hello from " + user}
+		if err := routing.Publish(ctx, b, routing.GameLogs, routing.Params{"username": user}, gl); err != nil {
+			t.Fatal(err)
+		}
+		d, ok, err := b.Get(routing.QueueGameLogs)
+		if err != nil || !ok {
+			t.Fatalf("Get = %v, %v", ok, err)
+		}
+		if err := d.Nack(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := run(b, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok, err := b.Get(routing.QueueGameLogs)
+	if err != nil || !ok {
+		t.Fatalf("replayed message: %v, %v", ok, err)
+	}
+	if d.RoutingKey != "game_logs.bob" {
+		t.Fatalf("replayed %s, want game_logs.bob", d.RoutingKey)
+	}
+	v, err := decodeBody(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gl, ok := v.(routing.GameLog); !ok || gl.Username != "bob" {
+		t.Fatalf("decoded %#v, want bob's GameLog", v)
+	}
+
+	// alice's message stays in the DLQ.
+	kept, ok, err := b.Get(routing.QueuePerilDLQ)
+	if err != nil || !ok {
+		t.Fatalf("kept message: %v, %v", ok, err)
+	}
+	if exchange, key, _ := origin(kept); exchange != routing.ExchangePerilTopic || key != "game_logs.alice" {
+		t.Fatalf("kept message from %s %s", exchange, key)
+	}
+	if _, ok, _ := b.Get(routing.QueuePerilDLQ); ok {
+		t.Fatal("the replayed message is still in the DLQ")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// gameClock runs the turns. It takes orders until the deadline, resolves
+// them, announces what happened and starts the next turn. Pausing stops
+// the clock with the time left in the turn, and resuming starts it again.
+// Once a turn ends the game, the clock stops for good.
+type gameClock struct {
+	broker pubsub.Broker
+	world  *gamelogic.World
+	length time.Duration
+	// retry is how long to wait before trying again to resolve a turn
+	// that couldn't be.
+	retry  time.Duration
+	pauses chan bool
+
+	mu       sync.Mutex
+	phase    gamelogic.TurnPhase
+	deadline time.Time
+}
+
+func newGameClock(broker pubsub.Broker, world *gamelogic.World, length time.Duration) *gameClock {
+	return &gameClock{
+		broker: broker,
+		world:  world,
+		length: length,
+		retry:  5 * time.Second,
+		pauses: make(chan bool),
+		phase:  gamelogic.PhaseOrders,
+	}
+}
+
+func (c *gameClock) State() gamelogic.PlayingState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return gamelogic.PlayingState{
+		IsPaused: c.world.Paused(),
+		Turn:     c.world.Turn(),
+		Phase:    c.phase,
+		Deadline: c.deadline,
+	}
+}
+
+// SetPaused pauses or resumes the game. It must only be called while run
+// is running.
+func (c *gameClock) SetPaused(paused bool) {
+	c.pauses <- paused
+}
+
+func (c *gameClock) run(ctx context.Context) {
+	left := c.length
+	timer := time.NewTimer(left)
+	defer timer.Stop()
+	if over := c.world.GameOver(); over != nil {
+		timer.Stop()
+		c.finish(ctx, *over)
+	} else {
+		if c.world.Paused() {
+			timer.Stop()
+		}
+		c.startTurn(ctx, left)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case paused := <-c.pauses:
+			wasPaused := c.world.Paused()
+			if err := c.world.SetPaused(paused); err != nil {
+				fmt.Printf("error saving pause: %v\n", err)
+				continue
+			}
+			switch {
+			case paused && !wasPaused:
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				left = max(time.Until(c.State().Deadline), 0)
+			case !paused && wasPaused && c.world.GameOver() == nil:
+				timer.Reset(left)
+				c.mu.Lock()
+				c.deadline = time.Now().Add(left)
+				c.mu.Unlock()
+			}
+			c.announce(ctx)
+		case <-timer.C:
+			if err := c.resolve(ctx); err != nil {
+				fmt.Printf("error resolving turn %d, retrying in %v: %v\n", c.world.Turn(), c.retry, err)
+				timer.Reset(c.retry)
+				continue
+			}
+			if over := c.world.GameOver(); over != nil {
+				c.finish(ctx, *over)
+				continue
+			}
+			left = c.length
+			timer.Reset(left)
+			c.startTurn(ctx, left)
+		}
+	}
+}
+
+func (c *gameClock) startTurn(ctx context.Context, left time.Duration) {
+	c.mu.Lock()
+	c.phase = gamelogic.PhaseOrders
+	c.deadline = time.Now().Add(left)
+	c.mu.Unlock()
+	c.announce(ctx)
+}
+
+// resolve carries out the turn and tells everyone what happened, logging
+// the wars. If the turn can't be resolved the world is unchanged and
+// nothing is published, and the clock tries again after c.retry.
+// Once the world has changed, publishing failures are only reported.
+func (c *gameClock) resolve(ctx context.Context) error {
+	c.mu.Lock()
+	c.phase = gamelogic.PhaseResolving
+	c.mu.Unlock()
+	c.announce(ctx)
+
+	t, err := c.world.ResolveTurn()
+	if err != nil {
+		return err
+	}
+	spawns := 0
+	for _, units := range t.Spawns {
+		spawns += len(units)
+	}
+	fmt.Printf("Turn %d resolved: %d spawns, %d moves, %d wars\n", t.Turn, spawns, len(t.Moves), len(t.Wars))
+	err = routing.Publish(ctx, c.broker, routing.Turns, routing.Params{"turn": strconv.Itoa(t.Turn)}, t)
+	if err != nil {
+		fmt.Printf("error publishing turn %d: %v\n", t.Turn, err)
+	}
+	for _, war := range t.Wars {
+		gl := routing.GameLog{CurrentTime: time.Now(), Username: war.Attacker}
+		if war.Winner != "" {
+			gl.Message = fmt.Sprintf("%s won against %s", war.Winner, war.Loser)
+		} else {
+			gl.Message = fmt.Sprintf("A war between %s and %s resulted in a draw", war.Attacker, war.Defender)
+		}
+		if err := routing.Publish(ctx, c.broker, routing.GameLogs, routing.Params{"username": gl.Username}, gl); err != nil {
+			fmt.Printf("error publishing game log: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func (c *gameClock) finish(ctx context.Context, over gamelogic.GameOver) {
+	c.mu.Lock()
+	c.phase = gamelogic.PhaseOver
+	c.mu.Unlock()
+	fmt.Printf("The game is over: %v won on turn %d\n", over.Winners, over.Turn)
+	if err := routing.Publish(ctx, c.broker, routing.GameOver, nil, over); err != nil {
+		fmt.Printf("error publishing the end of the game: %v\n", err)
+	}
+	c.announce(ctx)
+}
+
+func (c *gameClock) announce(ctx context.Context) {
+	if err := routing.Publish(ctx, c.broker, routing.Pause, nil, c.State()); err != nil {
+		fmt.Printf("error publishing the game clock: %v\n", err)
+	}
+}
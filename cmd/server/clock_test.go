@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// flakyStore fails every append while failing is set.
+type flakyStore struct {
+	*gamelogic.MemoryWorldStore
+	failing atomic.Bool
+}
+
+func (s *flakyStore) Append(events ...gamelogic.Event) error {
+	if s.failing.Load() {
+		return errors.New("disk full")
+	}
+	return s.MemoryWorldStore.Append(events...)
+}
+
+func TestClockRetriesTurnThatCantBeSaved(t *testing.T) {
+	broker := pubsub.NewMemoryServer().Connect()
+	defer broker.Close()
+	if err := routing.DeclareTopology(broker); err != nil {
+		t.Fatal(err)
+	}
+	store := &flakyStore{MemoryWorldStore: gamelogic.NewMemoryWorldStore()}
+	world, err := gamelogic.OpenWorld(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := world.Join(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	turns := make(chan gamelogic.TurnResolved, 10)
+	sub, err := routing.Subscribe(ctx, broker, routing.Turns, routing.TurnsQueue("alice"), pubsub.Transient,
+		pubsub.ValuesOnly(func(t gamelogic.TurnResolved) pubsub.SimpleAckType {
+			turns <- t
+			return pubsub.Ack
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	store.failing.Store(true)
+	clock := newGameClock(broker, world, 10*time.Millisecond)
+	clock.retry = 10 * time.Millisecond
+	go clock.run(ctx)
+
+	select {
+	case turn := <-turns:
+		t.Fatalf("published turn %d that couldn't be saved", turn.Turn)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := world.Turn(); got != 1 {
+		t.Fatalf("the clock moved on to turn %d", got)
+	}
+
+	store.failing.Store(false)
+	select {
+	case turn := <-turns:
+		if turn.Turn != 1 {
+			t.Fatalf("first published turn is %d, want 1", turn.Turn)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the turn was never resolved")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// as publishes everything the test does under username's client AppID.
+func as(t *testing.T, username string) {
+	t.Helper()
+	old := pubsub.AppID
+	pubsub.AppID = routing.ClientAppID(username)
+	t.Cleanup(func() { pubsub.AppID = old })
+}
+
+func TestServeGameChecksSender(t *testing.T) {
+	broker := pubsub.NewMemoryServer().Connect()
+	defer broker.Close()
+	if err := routing.DeclareTopology(broker); err != nil {
+		t.Fatal(err)
+	}
+	world := gamelogic.NewWorld()
+	stop, err := serveGame(broker, world, newGameClock(broker, world, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	ctx := context.Background()
+
+	as(t, "mallory")
+	if _, err := routing.Call(ctx, broker, routing.Join, nil, gamelogic.JoinRequest{Username: "alice"}); err == nil {
+		t.Fatal("mallory joined as alice")
+	}
+
+	as(t, "alice")
+	resp, err := routing.Call(ctx, broker, routing.Join, nil, gamelogic.JoinRequest{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := resp.Economy.Territories[0]
+	spawn := gamelogic.SpawnOrder{Player: "alice", Location: home, Rank: gamelogic.RankInfantry}
+	if _, err := routing.Call(ctx, broker, routing.SpawnOrders, nil, spawn); err != nil {
+		t.Fatalf("alice's own spawn: %v", err)
+	}
+	if _, err := world.ResolveTurn(); err != nil {
+		t.Fatal(err)
+	}
+
+	as(t, "mallory")
+	var remote *pubsub.RemoteError
+	_, err = routing.Call(ctx, broker, routing.SpawnOrders, nil, spawn)
+	if !errors.As(err, &remote) {
+		t.Fatalf("mallory spawning for alice = %v, want a RemoteError", err)
+	}
+	move := gamelogic.MoveOrder{Player: "alice", ToLocation: "europe", UnitIDs: []int{1}}
+	_, err = routing.Call(ctx, broker, routing.MoveOrders, nil, move)
+	if !errors.As(err, &remote) {
+		t.Fatalf("mallory moving alice's units = %v, want a RemoteError", err)
+	}
+
+	// as restores the AppID when the test ends.
+	pubsub.AppID = "peril_server"
+	if _, err := routing.Call(ctx, broker, routing.MoveOrders, nil, move); err == nil {
+		t.Fatal("took an order that didn't come from a client")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// serveDefinitions stands in for the management API with the definitions
+// of t.
+func serveDefinitions(t *testing.T, topology pubsub.Topology) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/definitions" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(topology.Definitions("/"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestDiff(t *testing.T) {
+	var out strings.Builder
+	drift, err := diff(&out, serveDefinitions(t, routing.Topology), "/", routing.Topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift {
+		t.Fatalf("drift against its own definitions:\n%s", out.String())
+	}
+
+	stale := routing.Topology
+	stale.Exchanges = stale.Exchanges[1:]
+	out.Reset()
+	drift, err = diff(&out, serveDefinitions(t, stale), "/", routing.Topology)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drift || !strings.Contains(out.String(), "missing exchange "+routing.Topology.Exchanges[0].Name) {
+		t.Fatalf("drift = %v, output:\n%s", drift, out.String())
+	}
+}
+
+func TestDiffManagementError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := diff(&strings.Builder{}, srv.URL, "/", routing.Topology); err == nil {
+		t.Fatal("want an error when the management API fails")
+	}
+}
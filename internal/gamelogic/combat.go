@@ -0,0 +1,298 @@
+package gamelogic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Battle is a war waiting to be resolved: the attacker has moved into a
+// territory where the defender has units.
+type Battle struct {
+	Turn          int
+	Location      Location
+	Terrain       Terrain
+	Attacker      string
+	Defender      string
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+}
+
+// CombatResolver decides how a battle goes. It mustn't change anything
+// itself: the World journals the WarResult, and applying it is what removes
+// the casualties. Report should say how the result was reached, because
+// players only see the result.
+type CombatResolver interface {
+	Resolve(b Battle) WarResult
+}
+
+// WithCombat resolves battles with r instead of PowerResolver.
+func WithCombat(r CombatResolver) WorldOption {
+	return func(w *World) {
+		w.combat = r
+	}
+}
+
+// PowerResolver is the original rule: the side with more power wins and
+// every one of the loser's units in the territory dies. On a draw, both
+// sides' units die.
+type PowerResolver struct{}
+
+func (PowerResolver) Resolve(b Battle) WarResult {
+	r := newWarResult(b)
+	r.AttackerPower = unitsToPowerLevel(b.AttackerUnits)
+	r.DefenderPower = unitsToPowerLevel(b.DefenderUnits)
+	r.Report = append(r.Report,
+		fmt.Sprintf("%s's units add up to %d power.", b.Attacker, r.AttackerPower),
+		fmt.Sprintf("%s's units add up to %d power.", b.Defender, r.DefenderPower),
+	)
+	decide(&r)
+	wipeOut(&r)
+	return r
+}
+
+// RulesResolver adds rules to PowerResolver. With none of them on it
+// resolves battles the same way.
+type RulesResolver struct {
+	// Matchups gives a unit matchupBonus percent more power when the other
+	// side has a unit of the rank it beats: infantry beat cavalry, cavalry
+	// beat artillery and artillery beat infantry.
+	Matchups bool
+	// Terrain applies the modifiers for the terrain the battle is fought on.
+	Terrain bool
+	// Dice has every unit roll a die with this many sides and add it to
+	// its side's power. Zero rolls no dice.
+	Dice int
+	// Seed seeds the dice. Each battle's rolls depend only on the seed, the
+	// turn, where it's fought and who fights it.
+	Seed int64
+	// PartialCasualties only kills some units: each side loses its share
+	// of units in proportion to the other side's share of the power, the
+	// winner half as many. The weakest units die first.
+	PartialCasualties bool
+}
+
+const matchupBonus = 50
+
+var beats = map[UnitRank]UnitRank{
+	RankInfantry:  RankCavalry,
+	RankCavalry:   RankArtillery,
+	RankArtillery: RankInfantry,
+}
+
+// ParseCombat returns the resolver for a -combat flag: "power", or a comma
+// separated list of the rules to turn on: matchups, terrain, dice and
+// partial.
+func ParseCombat(spec string, seed int64) (CombatResolver, error) {
+	if spec == "" || spec == "power" {
+		return PowerResolver{}, nil
+	}
+	r := RulesResolver{Seed: seed}
+	for _, rule := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(rule) {
+		case "matchups":
+			r.Matchups = true
+		case "terrain":
+			r.Terrain = true
+		case "dice":
+			r.Dice = 6
+		case "partial":
+			r.PartialCasualties = true
+		default:
+			return nil, fmt.Errorf("unknown combat rule %q", rule)
+		}
+	}
+	return r, nil
+}
+
+func (c RulesResolver) Resolve(b Battle) WarResult {
+	r := newWarResult(b)
+	var rng *rand.Rand
+	if c.Dice > 0 {
+		rng = rand.New(rand.NewSource(c.Seed ^ battleSeed(b)))
+	}
+	side := func(player string, units, enemy []Unit, defending bool) int {
+		enemyRanks := map[UnitRank]bool{}
+		for _, u := range enemy {
+			enemyRanks[u.Rank] = true
+		}
+		// Work in hundredths so percentages don't round away small units.
+		total := 0
+		for _, u := range units {
+			pct := 100
+			if c.Matchups && enemyRanks[beats[u.Rank]] {
+				pct += matchupBonus
+			}
+			if c.Terrain {
+				pct += terrainRules[b.Terrain].ranks[u.Rank]
+				if defending {
+					pct += terrainRules[b.Terrain].defense
+				}
+			}
+			total += unitsToPowerLevel([]Unit{u}) * max(pct, 0)
+		}
+		power := total / 100
+		if c.Matchups {
+			for _, rank := range getRanks(units) {
+				if enemyRanks[beats[rank]] {
+					r.Report = append(r.Report, fmt.Sprintf("%s's %s get +%d%% against %s.", player, rank, matchupBonus, beats[rank]))
+				}
+			}
+		}
+		if c.Terrain && defending {
+			if d := terrainRules[b.Terrain].defense; d != 0 {
+				r.Report = append(r.Report, fmt.Sprintf("%s gets %+d%% for defending %s.", player, d, b.Terrain))
+			}
+		}
+		r.Report = append(r.Report, fmt.Sprintf("%s's units add up to %d power.", player, power))
+		if rng != nil {
+			rolls := make([]string, len(units))
+			for i := range units {
+				n := rng.Intn(c.Dice) + 1
+				power += n
+				rolls[i] = fmt.Sprint(n)
+			}
+			r.Report = append(r.Report, fmt.Sprintf("%s rolls %s, for %d power.", player, strings.Join(rolls, ", "), power))
+		}
+		return power
+	}
+
+	if c.Terrain {
+		for _, rank := range getRanks(append(append([]Unit(nil), b.AttackerUnits...), b.DefenderUnits...)) {
+			if m := terrainRules[b.Terrain].ranks[rank]; m != 0 {
+				r.Report = append(r.Report, fmt.Sprintf("%s fight at %+d%% in %s.", rank, m, b.Terrain))
+			}
+		}
+	}
+	r.AttackerPower = side(b.Attacker, b.AttackerUnits, b.DefenderUnits, false)
+	r.DefenderPower = side(b.Defender, b.DefenderUnits, b.AttackerUnits, true)
+	decide(&r)
+	if c.PartialCasualties {
+		partialCasualties(&r)
+	} else {
+		wipeOut(&r)
+	}
+	return r
+}
+
+func newWarResult(b Battle) WarResult {
+	return WarResult{
+		Attacker:      b.Attacker,
+		Defender:      b.Defender,
+		Location:      b.Location,
+		AttackerUnits: b.AttackerUnits,
+		DefenderUnits: b.DefenderUnits,
+		Casualties:    map[string][]int{},
+	}
+}
+
+// decide sets the winner and loser from the power each side ended up with.
+func decide(r *WarResult) {
+	switch {
+	case r.AttackerPower > r.DefenderPower:
+		r.Winner, r.Loser = r.Attacker, r.Defender
+	case r.DefenderPower > r.AttackerPower:
+		r.Winner, r.Loser = r.Defender, r.Attacker
+	}
+}
+
+// wipeOut kills all of the loser's units, or everyone's on a draw.
+func wipeOut(r *WarResult) {
+	if r.Loser != r.Attacker {
+		killUnits(r, r.Defender, r.DefenderUnits)
+	}
+	if r.Loser != r.Defender {
+		killUnits(r, r.Attacker, r.AttackerUnits)
+	}
+}
+
+func partialCasualties(r *WarResult) {
+	total := r.AttackerPower + r.DefenderPower
+	losses := func(units []Unit, enemyPower int, won bool) []Unit {
+		n := len(units)
+		if total > 0 {
+			n = (len(units)*enemyPower + total - 1) / total
+		}
+		if won {
+			n /= 2
+		} else {
+			n = max(n, 1)
+		}
+		// The weakest units fall first, and the newest of equal rank.
+		weakest := append([]Unit(nil), units...)
+		sort.SliceStable(weakest, func(i, j int) bool {
+			pi, pj := unitsToPowerLevel(weakest[i:i+1]), unitsToPowerLevel(weakest[j:j+1])
+			if pi != pj {
+				return pi < pj
+			}
+			return weakest[i].ID > weakest[j].ID
+		})
+		return weakest[:min(n, len(weakest))]
+	}
+	killUnits(r, r.Attacker, losses(r.AttackerUnits, r.DefenderPower, r.Winner == r.Attacker))
+	killUnits(r, r.Defender, losses(r.DefenderUnits, r.AttackerPower, r.Winner == r.Defender))
+}
+
+func killUnits(r *WarResult, player string, units []Unit) {
+	for _, u := range units {
+		r.Casualties[player] = append(r.Casualties[player], u.ID)
+	}
+	if len(units) > 0 {
+		r.Report = append(r.Report, fmt.Sprintf("%s loses %d of %d unit(s).", player, len(units), r.unitCount(player)))
+	}
+}
+
+func (r WarResult) unitCount(player string) int {
+	if player == r.Attacker {
+		return len(r.AttackerUnits)
+	}
+	return len(r.DefenderUnits)
+}
+
+// battleSeed mixes in what makes a battle different from the others fought
+// with the same seed.
+func battleSeed(b Battle) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s/%s", b.Turn, b.Location, b.Attacker, b.Defender)
+	return int64(h.Sum64())
+}
+
+// getRanks returns the ranks among units: infantry, cavalry, then artillery.
+func getRanks(units []Unit) []UnitRank {
+	has := map[UnitRank]bool{}
+	for _, u := range units {
+		has[u.Rank] = true
+	}
+	var ranks []UnitRank
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry, RankArtillery} {
+		if has[rank] {
+			ranks = append(ranks, rank)
+		}
+	}
+	return ranks
+}
+
+// Terrain is what a territory is like to fight in. A territory with no
+// terrain is plains.
+type Terrain string
+
+const (
+	TerrainPlains    Terrain = "plains"
+	TerrainForest    Terrain = "forest"
+	TerrainMountains Terrain = "mountains"
+	TerrainIce       Terrain = "ice"
+)
+
+// terrainRules are the percentages added to the defender's power, and to
+// each rank's, when fighting in a terrain.
+var terrainRules = map[Terrain]struct {
+	defense int
+	ranks   map[UnitRank]int
+}{
+	TerrainPlains:    {ranks: map[UnitRank]int{RankCavalry: 25}},
+	TerrainForest:    {defense: 25, ranks: map[UnitRank]int{RankCavalry: -50}},
+	TerrainMountains: {defense: 50, ranks: map[UnitRank]int{RankCavalry: -50, RankArtillery: -25}},
+	TerrainIce:       {ranks: map[UnitRank]int{RankCavalry: -50, RankArtillery: -50}},
+}
@@ -0,0 +1,240 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+// units makes n units of rank, numbered from first.
+func units(rank UnitRank, first, n int) []Unit {
+	out := make([]Unit, n)
+	for i := range out {
+		out[i] = Unit{ID: first + i, Rank: rank, Location: "europe"}
+	}
+	return out
+}
+
+func battle(terrain Terrain, attacker, defender []Unit) Battle {
+	return Battle{
+		Turn:          1,
+		Location:      "europe",
+		Terrain:       terrain,
+		Attacker:      "alice",
+		Defender:      "bob",
+		AttackerUnits: attacker,
+		DefenderUnits: defender,
+	}
+}
+
+func TestRulesResolverWithoutRulesIsPowerResolver(t *testing.T) {
+	battles := map[string]Battle{
+		"attacker wins": battle(TerrainPlains, units(RankCavalry, 1, 1), units(RankInfantry, 1, 2)),
+		"defender wins": battle(TerrainPlains, units(RankInfantry, 1, 2), units(RankArtillery, 1, 1)),
+		"draw":          battle(TerrainPlains, units(RankInfantry, 1, 5), units(RankCavalry, 1, 1)),
+	}
+	for name, b := range battles {
+		t.Run(name, func(t *testing.T) {
+			want := PowerResolver{}.Resolve(b)
+			if got := (RulesResolver{}).Resolve(b); !reflect.DeepEqual(got, want) {
+				t.Fatalf("RulesResolver{} = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestPowerResolver(t *testing.T) {
+	r := PowerResolver{}.Resolve(battle(TerrainPlains, units(RankCavalry, 1, 1), units(RankInfantry, 1, 2)))
+	if r.Winner != "alice" || r.Loser != "bob" {
+		t.Fatalf("winner %q, loser %q", r.Winner, r.Loser)
+	}
+	want := map[string][]int{"bob": {1, 2}}
+	if !reflect.DeepEqual(r.Casualties, want) {
+		t.Fatalf("Casualties = %v, want %v", r.Casualties, want)
+	}
+
+	draw := PowerResolver{}.Resolve(battle(TerrainPlains, units(RankInfantry, 1, 5), units(RankCavalry, 1, 1)))
+	if draw.Winner != "" || len(draw.Casualties["alice"]) != 5 || len(draw.Casualties["bob"]) != 1 {
+		t.Fatalf("a draw = %+v, want both sides wiped out", draw)
+	}
+}
+
+func TestRulesResolverRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  RulesResolver
+		b      Battle
+		winner string
+	}{
+		{
+			// 5 infantry at +50% against the cavalry they beat: 7 to 5.
+			name:   "matchups",
+			rules:  RulesResolver{Matchups: true},
+			b:      battle(TerrainPlains, units(RankInfantry, 1, 5), units(RankCavalry, 1, 1)),
+			winner: "alice",
+		},
+		{
+			// Cavalry gets +25% on plains: 6 to 5.
+			name:   "plains",
+			rules:  RulesResolver{Terrain: true},
+			b:      battle(TerrainPlains, units(RankCavalry, 1, 1), units(RankInfantry, 1, 5)),
+			winner: "alice",
+		},
+		{
+			// Cavalry at -50% against infantry defending at +50%: 2 to 7.
+			name:   "mountains",
+			rules:  RulesResolver{Terrain: true},
+			b:      battle(TerrainMountains, units(RankCavalry, 1, 1), units(RankInfantry, 1, 5)),
+			winner: "bob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.rules.Resolve(tt.b)
+			if r.Winner != tt.winner {
+				t.Fatalf("winner = %q (%d to %d), want %q\n%v",
+					r.Winner, r.AttackerPower, r.DefenderPower, tt.winner, r.Report)
+			}
+			if len(r.Report) == 0 {
+				t.Fatal("no report")
+			}
+		})
+	}
+}
+
+func TestRulesResolverDice(t *testing.T) {
+	b := battle(TerrainPlains, units(RankInfantry, 1, 4), units(RankInfantry, 1, 4))
+	rules := RulesResolver{Dice: 6, Seed: 42}
+	first := rules.Resolve(b)
+	if again := rules.Resolve(b); !reflect.DeepEqual(again, first) {
+		t.Fatalf("the same battle and seed resolved differently:\n%+v\n%+v", first, again)
+	}
+	for _, power := range []int{first.AttackerPower, first.DefenderPower} {
+		// 4 power, plus four rolls of 1 to 6.
+		if power < 4+4 || power > 4+4*6 {
+			t.Fatalf("power %d is out of range for four d6", power)
+		}
+	}
+
+	differ := false
+	for turn := 2; turn < 20 && !differ; turn++ {
+		later := b
+		later.Turn = turn
+		r := rules.Resolve(later)
+		differ = r.AttackerPower != first.AttackerPower || r.DefenderPower != first.DefenderPower
+	}
+	if !differ {
+		t.Fatal("every turn rolled the same dice")
+	}
+}
+
+func TestRulesResolverPartialCasualties(t *testing.T) {
+	// 10 power against 4. bob loses ceil(4 * 10/14) = 3 units, alice
+	// ceil(2 * 4/14) = 1 halved to none for winning.
+	b := battle(TerrainPlains, units(RankCavalry, 1, 2), units(RankInfantry, 1, 4))
+	r := RulesResolver{PartialCasualties: true}.Resolve(b)
+	want := map[string][]int{"bob": {4, 3, 2}}
+	if !reflect.DeepEqual(r.Casualties, want) {
+		t.Fatalf("Casualties = %v, want %v", r.Casualties, want)
+	}
+
+	// alice loses their only unit; bob loses ceil(2 * 1/12) = 1, halved to none.
+	mixed := append(units(RankArtillery, 1, 1), units(RankInfantry, 2, 1)...)
+	r = RulesResolver{PartialCasualties: true}.Resolve(battle(TerrainPlains, units(RankInfantry, 10, 1), mixed))
+	if want := map[string][]int{"alice": {10}}; !reflect.DeepEqual(r.Casualties, want) {
+		t.Fatalf("Casualties = %v, want %v", r.Casualties, want)
+	}
+}
+
+func TestParseCombat(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    CombatResolver
+		wantErr bool
+	}{
+		{spec: "", want: PowerResolver{}},
+		{spec: "power", want: PowerResolver{}},
+		{spec: "matchups, dice", want: RulesResolver{Matchups: true, Dice: 6, Seed: 7}},
+		{spec: "terrain,partial", want: RulesResolver{Terrain: true, PartialCasualties: true, Seed: 7}},
+		{spec: "matchups,luck", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCombat(tt.spec, 7)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCombat(%q) = %+v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCombat(%q) = %+v, %v; want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateTerrain(t *testing.T) {
+	m := WorldMap{Territories: []Territory{{Name: "swamp", Terrain: "swamp"}}}
+	if err := m.Validate(); err == nil {
+		t.Fatal("a map with unknown terrain is valid")
+	}
+	m.Territories[0].Terrain = TerrainForest
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := DefaultWorldMap().TerrainOf("americas"); got != TerrainPlains {
+		t.Fatalf("TerrainOf(americas) = %q, want plains", got)
+	}
+}
+
+// recordingResolver lets the defender win and remembers the battles it was
+// given.
+type recordingResolver struct {
+	battles *[]Battle
+}
+
+func (r recordingResolver) Resolve(b Battle) WarResult {
+	*r.battles = append(*r.battles, b)
+	res := newWarResult(b)
+	res.Winner, res.Loser = b.Defender, b.Attacker
+	killUnits(&res, b.Attacker, b.AttackerUnits)
+	return res
+}
+
+func TestWorldUsesCombatResolver(t *testing.T) {
+	var battles []Battle
+	w := NewWorld(WithCombat(recordingResolver{&battles}))
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := w.Join(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, o := range []SpawnOrder{
+		{Player: "alice", Location: "americas", Rank: RankCavalry},
+		{Player: "bob", Location: "europe", Rank: RankInfantry},
+	} {
+		if _, err := w.QueueSpawn(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.ResolveTurn(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: "europe", UnitIDs: []int{1}}); err != nil {
+		t.Fatal(err)
+	}
+	turn, err := w.ResolveTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(battles) != 1 || battles[0].Terrain != TerrainForest || battles[0].Attacker != "alice" {
+		t.Fatalf("battles = %+v, want alice attacking in europe's forest", battles)
+	}
+	if len(turn.Wars) != 1 || turn.Wars[0].Winner != "bob" {
+		t.Fatalf("wars = %+v", turn.Wars)
+	}
+	for _, p := range w.Players() {
+		if p.Username == "alice" && len(p.Units) != 0 {
+			t.Fatalf("alice's casualties are still alive: %+v", p.Units)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package gamelogic
+
+import "fmt"
+
+// StartingTreasury is what a player has to spend when they join.
+const StartingTreasury = 10
+
+// RankCost is what a unit costs to spawn, and then every turn to keep.
+type RankCost struct {
+	Cost   int
+	Upkeep int
+}
+
+var rankCosts = map[UnitRank]RankCost{
+	RankInfantry:  {Cost: 1, Upkeep: 0},
+	RankCavalry:   {Cost: 4, Upkeep: 1},
+	RankArtillery: {Cost: 8, Upkeep: 2},
+}
+
+func CostOf(rank UnitRank) RankCost {
+	return rankCosts[rank]
+}
+
+// Economy is a player's finances. Income is what their territories bring
+// in each turn and Upkeep what their units cost to keep; the difference is
+// added to the treasury when a turn is resolved, which never goes below
+// zero.
+type Economy struct {
+	Treasury    int
+	Income      int
+	Upkeep      int
+	Territories []Location
+}
+
+func (e Economy) Controls(loc Location) bool {
+	for _, t := range e.Territories {
+		if t == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// Economy returns a player's finances as they stand.
+func (w *World) Economy(username string) Economy {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.economy(username)
+}
+
+func (w *World) economy(username string) Economy {
+	e := Economy{Treasury: w.treasury[username], Territories: []Location{}}
+	for _, t := range w.m.Territories {
+		if w.control[t.Name] == username {
+			e.Income += t.Income
+			e.Territories = append(e.Territories, t.Name)
+		}
+	}
+	if p, ok := w.players[username]; ok {
+		for _, u := range p.Units {
+			e.Upkeep += CostOf(u.Rank).Upkeep
+		}
+	}
+	return e
+}
+
+// pendingCost is what a player's queued spawns will cost.
+func (w *World) pendingCost(username string) int {
+	cost := 0
+	for _, o := range w.spawns {
+		if o.Player == username {
+			cost += CostOf(o.Rank).Cost
+		}
+	}
+	return cost
+}
+
+// checkSpawn reports whether a player can afford a spawn in loc, on top of
+// the spawns they've already queued.
+func (w *World) checkSpawn(o SpawnOrder, pending int) error {
+	if w.control[o.Location] != o.Player {
+		return fmt.Errorf("%s doesn't control %s", o.Player, o.Location)
+	}
+	cost := CostOf(o.Rank).Cost
+	if left := w.treasury[o.Player] - pending; cost > left {
+		return fmt.Errorf("a(n) %s costs %d, and %s has %d left to spend", o.Rank, cost, o.Player, left)
+	}
+	return nil
+}
+
+// freeTerritory finds a territory nobody controls or occupies, in map
+// order, for a new player to start in.
+func (w *World) freeTerritory() (Location, bool) {
+	occupied := map[Location]bool{}
+	for _, p := range w.players {
+		for _, u := range p.Units {
+			occupied[u.Location] = true
+		}
+	}
+	for _, t := range w.m.Territories {
+		if w.control[t.Name] == "" && !occupied[t.Name] {
+			return t.Name, true
+		}
+	}
+	return "", false
+}
+
+// occupants returns the players with units in each territory.
+func (w *World) occupants() map[Location][]string {
+	out := map[Location][]string{}
+	for _, name := range w.sortedPlayers() {
+		seen := map[Location]bool{}
+		for _, u := range w.players[name].Units {
+			if !seen[u.Location] {
+				seen[u.Location] = true
+				out[u.Location] = append(out[u.Location], name)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinEconomy(t *testing.T) {
+	w := NewWorld()
+	if _, err := w.Join("alice"); err != nil {
+		t.Fatal(err)
+	}
+	want := Economy{Treasury: StartingTreasury, Income: 3, Territories: []Location{"americas"}}
+	if got := w.Economy("alice"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Economy = %+v, want %+v", got, want)
+	}
+}
+
+func TestQueueSpawnCosts(t *testing.T) {
+	w := NewWorld()
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := w.Join(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spawn := func(rank UnitRank, loc Location) error {
+		_, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: loc, Rank: rank})
+		return err
+	}
+	if err := spawn(RankCavalry, "americas"); err != nil {
+		t.Fatal(err)
+	}
+	if err := spawn(RankInfantry, "americas"); err != nil {
+		t.Fatal(err)
+	}
+	// 10 - 4 - 1 leaves 5, short of an artillery.
+	if err := spawn(RankArtillery, "americas"); err == nil {
+		t.Fatal("queued a spawn alice can't afford")
+	}
+	if err := spawn(RankInfantry, "europe"); err == nil {
+		t.Fatal("spawned in bob's territory")
+	}
+	if err := spawn(RankInfantry, "africa"); err == nil {
+		t.Fatal("spawned in a territory nobody controls")
+	}
+}
+
+func TestResolveTurnIncomeAndUpkeep(t *testing.T) {
+	w := NewWorld()
+	if _, err := w.Join("alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry} {
+		if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: "americas", Rank: rank}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	turn, err := w.ResolveTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 - 1 - 4 for the spawns, + 3 income, - 1 cavalry upkeep.
+	want := Economy{Treasury: 7, Income: 3, Upkeep: 1, Territories: []Location{"americas"}}
+	if got := turn.Economies["alice"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after spawning, Economy = %+v, want %+v", got, want)
+	}
+
+	// Moving into an empty territory takes control of it, and the home
+	// territory stays alice's after the units leave.
+	if _, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: "europe", UnitIDs: []int{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	turn, err = w.ResolveTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Economy{Treasury: 12, Income: 6, Upkeep: 1, Territories: []Location{"americas", "europe"}}
+	if got := turn.Economies["alice"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after moving, Economy = %+v, want %+v", got, want)
+	}
+}
+
+func TestTreasuryNeverGoesNegative(t *testing.T) {
+	w := NewWorld(WithMap(WorldMap{Territories: []Territory{{Name: "island"}}}))
+	if _, err := w.Join("alice"); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: "island", Rank: RankCavalry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []int{0, 0} {
+		turn, err := w.ResolveTurn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := turn.Economies["alice"].Treasury; got != want {
+			t.Fatalf("turn %d: treasury = %d, want %d", turn.Turn, got, want)
+		}
+	}
+}
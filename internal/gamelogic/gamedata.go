@@ -1,5 +1,7 @@
 package gamelogic
 
+import "time"
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
@@ -19,15 +21,74 @@ type Unit struct {
 	Location Location
 }
 
+// ArmyMove is a move the server has made. Paths holds the way each unit
+// went, by unit ID, from where it was to ToLocation.
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
+	Paths      map[int][]Location
+}
+
+// SpawnOrder and MoveOrder are what clients ask the server to do. The
+// server answers each with an OrderAck and carries it out when the turn is
+// resolved.
+type SpawnOrder struct {
+	Player   string
+	Location Location
+	Rank     UnitRank
+}
+
+type MoveOrder struct {
+	Player     string
+	ToLocation Location
+	UnitIDs    []int
+}
+
+// OrderAck tells a client which turn its order will be carried out in.
+type OrderAck struct {
+	Turn     int
+	Deadline time.Time
 }
 
-type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+// TurnResolved is everything that happened in a turn, in the order it
+// happened: the units spawned, by player, then the moves, then the wars.
+// Economies has every player's finances at the end of the turn.
+type TurnResolved struct {
+	Turn      int
+	Spawns    map[string][]Unit
+	Moves     []ArmyMove
+	Wars      []WarResult
+	Economies map[string]Economy
+}
+
+type JoinRequest struct {
+	Username string
+}
+
+// JoinResponse gives a player their units, their finances and the map the
+// server plays on.
+type JoinResponse struct {
+	Player  Player
+	Economy Economy
+	Map     WorldMap
+}
+
+// WarResult is the server's ruling on a war. Winner and Loser are empty on
+// a draw. Casualties lists the IDs of the units each player lost.
+type WarResult struct {
+	Attacker      string
+	Defender      string
+	Location      Location
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+	AttackerPower int
+	DefenderPower int
+	Winner        string
+	Loser         string
+	Casualties    map[string][]int
+	// Report is how the CombatResolver got its result, line by line.
+	Report []string `json:",omitempty"`
 }
 
 type Location string
@@ -39,14 +100,3 @@ func getAllRanks() map[UnitRank]struct{} {
 		RankArtillery: {},
 	}
 }
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
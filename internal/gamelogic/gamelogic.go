@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"strings"
+	"time"
 )
 
 func PrintClientHelp() {
@@ -14,10 +16,20 @@ func PrintClientHelp() {
 	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
+	fmt.Println("    how far each rank can go in a turn, in edge cost:")
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry, RankArtillery} {
+		fmt.Printf("    %s %d\n", rank, MovementOf(rank))
+	}
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("    costs (upkeep per turn):")
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry, RankArtillery} {
+		c := CostOf(rank)
+		fmt.Printf("    %s %d (%d)\n", rank, c.Cost, c.Upkeep)
+	}
 	fmt.Println("* status")
+	fmt.Println("* map")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -77,16 +89,39 @@ func PrintQuit() {
 }
 
 func (gs *GameState) CommandStatus() {
-	if gs.isPaused() {
-		fmt.Println("The game is paused.")
-		return
+	ps := gs.getPlayingState()
+	if ps.IsPaused {
+		fmt.Printf("The game is paused on turn %d.\n", ps.Turn)
 	} else {
-		fmt.Println("The game is not paused.")
+		fmt.Printf("The game is not paused. It is turn %d; orders are due by %s.\n", ps.Turn, ps.Deadline.Format(time.TimeOnly))
 	}
 
+	e := gs.getEconomy()
+	fmt.Printf("Treasury: %d, income: %d, upkeep: %d per turn\n", e.Treasury, e.Income, e.Upkeep)
+	fmt.Printf("You control: %s\n", joinLocations(e.Territories, ", "))
+
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
 }
+
+// CommandMap lists the territories, their income and what it costs to
+// reach each neighbor.
+func (gs *GameState) CommandMap() {
+	m := gs.getMap()
+	for _, t := range m.Territories {
+		neighbors := m.Neighbors(t.Name)
+		names := make([]string, 0, len(neighbors))
+		for loc := range neighbors {
+			names = append(names, string(loc))
+		}
+		sort.Strings(names)
+		fmt.Printf("* %s (%s, income %d):", t.Name, m.TerrainOf(t.Name), t.Income)
+		for _, name := range names {
+			fmt.Printf(" %s (%d)", name, neighbors[Location(name)])
+		}
+		fmt.Println()
+	}
+}
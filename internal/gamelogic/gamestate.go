@@ -2,12 +2,18 @@ package gamelogic
 
 import (
 	"sync"
+	"time"
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player   Player
+	Paused   bool
+	Turn     int
+	Phase    TurnPhase
+	Deadline time.Time
+	Economy  Economy
+	Map      WorldMap
+	mu       *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -17,20 +23,34 @@ func NewGameState(username string) *GameState {
 			Units:    map[int]Unit{},
 		},
 		Paused: false,
+		Map:    DefaultWorldMap(),
 		mu:     &sync.RWMutex{},
 	}
 }
 
-func (gs *GameState) resumeGame() {
+// setPlayingState updates the game clock and reports whether the game was
+// paused before.
+func (gs *GameState) setPlayingState(ps PlayingState) (wasPaused bool) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = false
+	wasPaused = gs.Paused
+	gs.Paused = ps.IsPaused
+	gs.Turn = ps.Turn
+	gs.Phase = ps.Phase
+	gs.Deadline = ps.Deadline
+	return wasPaused
 }
 
-func (gs *GameState) pauseGame() {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Paused = true
+func (gs *GameState) isOver() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Phase == PhaseOver
+}
+
+func (gs *GameState) getPlayingState() PlayingState {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return PlayingState{IsPaused: gs.Paused, Turn: gs.Turn, Phase: gs.Phase, Deadline: gs.Deadline}
 }
 
 func (gs *GameState) isPaused() bool {
@@ -45,16 +65,48 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) removeUnit(id int) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
+	delete(gs.Player.Units, id)
+}
+
+// SetUnits replaces our units with the ones the server has for us.
+func (gs *GameState) SetUnits(units map[int]Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	for id, u := range units {
+		gs.Player.Units[id] = u
 	}
 }
 
+// SetEconomy replaces our finances with the server's.
+func (gs *GameState) SetEconomy(e Economy) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Economy = e
+}
+
+func (gs *GameState) getEconomy() Economy {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Economy
+}
+
+// SetMap replaces the map with the one the server plays on.
+func (gs *GameState) SetMap(m WorldMap) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Map = m
+}
+
+func (gs *GameState) getMap() WorldMap {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Map
+}
+
 func (gs *GameState) UpdateUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
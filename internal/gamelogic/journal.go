@@ -0,0 +1,122 @@
+package gamelogic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventJoin  EventKind = "join"
+	EventSpawn EventKind = "spawn"
+	EventMove  EventKind = "move"
+	EventWar   EventKind = "war"
+	EventPause EventKind = "pause"
+	EventTurn  EventKind = "turn"
+	// EventIncome sets a player's treasury after a turn's income and
+	// upkeep.
+	EventIncome   EventKind = "income"
+	EventControl  EventKind = "control"
+	EventGameOver EventKind = "game_over"
+)
+
+// Event is one change to the World, as it was made. Replaying events
+// applies their recorded outcome rather than re-running the rules, so a
+// journal still replays the same way after the rules change.
+type Event struct {
+	Seq    uint64
+	Time   time.Time
+	Kind   EventKind
+	Player string     `json:",omitempty"`
+	Unit   *Unit      `json:",omitempty"`
+	Move   *ArmyMove  `json:",omitempty"`
+	War    *WarResult `json:",omitempty"`
+	Paused bool       `json:",omitempty"`
+	Turn   int        `json:",omitempty"`
+	// Territory is a new player's home, or the territory that changed
+	// hands.
+	Territory Location `json:",omitempty"`
+	// Treasury is the player's treasury after the event.
+	Treasury int       `json:",omitempty"`
+	GameOver *GameOver `json:",omitempty"`
+}
+
+// Snapshot is the whole World as of event Seq.
+type Snapshot struct {
+	Seq      uint64
+	Time     time.Time
+	Turn     int
+	Paused   bool
+	Players  []Player
+	NextID   map[string]int
+	Treasury map[string]int
+	Control  map[Location]string
+	// ControlSince is the turn each territory's holder took it.
+	ControlSince map[Location]int
+	Home         map[string]Location
+	GameOver     *GameOver
+}
+
+// WorldStore keeps a World's latest snapshot and the journal of events
+// since. Saving a snapshot lets the store forget the events it covers.
+type WorldStore interface {
+	// Append journals events all together: after a crash or an error,
+	// either all of them are in the journal or none are.
+	Append(events ...Event) error
+	// Events returns the journal's events after seq, in order.
+	Events(after uint64) ([]Event, error)
+	SaveSnapshot(s Snapshot) error
+	// LoadSnapshot reports false if no snapshot has been saved yet.
+	LoadSnapshot() (Snapshot, bool, error)
+	Close() error
+}
+
+// MemoryWorldStore keeps everything in memory, for games that needn't
+// survive a restart.
+type MemoryWorldStore struct {
+	mu       sync.Mutex
+	snapshot *Snapshot
+	events   []Event
+}
+
+func NewMemoryWorldStore() *MemoryWorldStore {
+	return &MemoryWorldStore{}
+}
+
+func (s *MemoryWorldStore) Append(events ...Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *MemoryWorldStore) Events(after uint64) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].Seq > after })
+	return append([]Event(nil), s.events[i:]...), nil
+}
+
+func (s *MemoryWorldStore) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = &snap
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].Seq > snap.Seq })
+	s.events = append([]Event(nil), s.events[i:]...)
+	return nil
+}
+
+func (s *MemoryWorldStore) LoadSnapshot() (Snapshot, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot == nil {
+		return Snapshot{}, false, nil
+	}
+	return *s.snapshot, true, nil
+}
+
+func (s *MemoryWorldStore) Close() error {
+	return nil
+}
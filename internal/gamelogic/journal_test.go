@@ -0,0 +1,97 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// playTurn queues a spawn for every player at home and resolves the turn.
+func playTurn(t *testing.T, w *World) {
+	t.Helper()
+	for _, p := range w.Players() {
+		home := w.Economy(p.Username).Territories[0]
+		if _, err := w.QueueSpawn(SpawnOrder{Player: p.Username, Location: home, Rank: RankInfantry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.ResolveTurn(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenWorldRestoresAfterRestart(t *testing.T) {
+	stores := map[string]func(t *testing.T, dir string) WorldStore{
+		"file": func(t *testing.T, dir string) WorldStore {
+			s, err := OpenFileWorldStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"bolt": func(t *testing.T, dir string) WorldStore {
+			s, err := OpenBoltWorldStore(filepath.Join(dir, "world.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	for name, open := range stores {
+		for _, snapshot := range []bool{false, true} {
+			sub := name + "/journal only"
+			if snapshot {
+				sub = name + "/snapshot"
+			}
+			t.Run(sub, func(t *testing.T) {
+				dir := t.TempDir()
+				store := open(t, dir)
+				w, err := OpenWorld(store)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, player := range []string{"alice", "bob"} {
+					if _, err := w.Join(player); err != nil {
+						t.Fatal(err)
+					}
+				}
+				playTurn(t, w)
+				if snapshot {
+					if err := w.SaveSnapshot(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				playTurn(t, w)
+				if err := w.SetPaused(true); err != nil {
+					t.Fatal(err)
+				}
+				want := w.Snapshot()
+				if err := store.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				store = open(t, dir)
+				defer store.Close()
+				restored, err := OpenWorld(store)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := restored.Snapshot()
+				got.Time, want.Time = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("restored world differs:\n%+v\n%+v", got, want)
+				}
+
+				// The restored world carries on journaling where it left off.
+				if err := restored.SetPaused(false); err != nil {
+					t.Fatal(err)
+				}
+				playTurn(t, restored)
+				if restored.Turn() != 4 {
+					t.Fatalf("turn is %d, want 4", restored.Turn())
+				}
+			})
+		}
+	}
+}
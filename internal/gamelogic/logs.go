@@ -5,15 +5,19 @@ import (
 	"log"
 	"os"
 	"time"
-
-	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
+type GameLog struct {
+	CurrentTime time.Time
+	Message     string
+	Username    string
+}
+
 const logsFile = "game.log"
 
 const writeToDiskSleep = 1 * time.Second
 
-func WriteLog(gamelog routing.GameLog) error {
+func WriteLog(gamelog GameLog) error {
 	log.Printf("received game log...")
 	time.Sleep(writeToDiskSleep)
 
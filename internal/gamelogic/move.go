@@ -4,89 +4,126 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
+// rankMovement is how far each rank can travel in a turn, counted in edge
+// cost.
+var rankMovement = map[UnitRank]int{
+	RankInfantry:  2,
+	RankCavalry:   3,
+	RankArtillery: 2,
+}
+
+func MovementOf(rank UnitRank) int {
+	return rankMovement[rank]
+}
+
 type MoveOutcome int
 
 const (
 	MoveOutcomeSamePlayer MoveOutcome = iota
 	MoveOutComeSafe
 	MoveOutcomeMakeWar
+	MoveOutcomeInvalid
 )
 
+// HandleMove applies a move the server has made. Our own moves update our
+// units; anyone else's may have started a war, which the server resolves
+// and reports separately. A move whose units didn't keep to the map's
+// edges, or went further than they can in a turn, is ignored.
 func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	defer fmt.Println("------------------------")
-	player := gs.GetPlayerSnap()
-
 	fmt.Println()
 	fmt.Println("==== Move Detected ====")
 	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
+	m := gs.getMap()
 	for _, unit := range move.Units {
-		fmt.Printf("* %v\n", unit.Rank)
+		path := move.Paths[unit.ID]
+		if len(path) == 0 || path[len(path)-1] != move.ToLocation {
+			fmt.Printf("Ignoring the move: unit %v has no path to %s\n", unit.ID, move.ToLocation)
+			return MoveOutcomeInvalid
+		}
+		cost, err := m.CheckPath(path)
+		if err != nil {
+			fmt.Printf("Ignoring the move: unit %v %v\n", unit.ID, err)
+			return MoveOutcomeInvalid
+		}
+		if budget := MovementOf(unit.Rank); cost > budget {
+			fmt.Printf("Ignoring the move: unit %v went %d, and a(n) %s can only go %d\n", unit.ID, cost, unit.Rank, budget)
+			return MoveOutcomeInvalid
+		}
+		fmt.Printf("* %v via %s (cost %d)\n", unit.Rank, formatPath(path), cost)
 	}
 
-	if player.Username == move.Player.Username {
+	if gs.GetUsername() == move.Player.Username {
+		for _, unit := range move.Units {
+			gs.UpdateUnit(unit)
+		}
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
-		return MoveOutcomeMakeWar
+	for _, unit := range gs.getUnitsSnap() {
+		if unit.Location == move.ToLocation {
+			fmt.Printf("You have units in %s! You are at war with %s!\n", move.ToLocation, move.Player.Username)
+			return MoveOutcomeMakeWar
+		}
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
-	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
-		}
+// CommandMove checks a move command against the units we know about and
+// turns it into an order for the server.
+func (gs *GameState) CommandMove(words []string) (MoveOrder, error) {
+	if gs.isOver() {
+		return MoveOrder{}, errors.New("the game is over, you can not move units")
 	}
-	return ""
-}
-
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+		return MoveOrder{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return MoveOrder{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	m := gs.getMap()
+	if !m.Has(newLocation) {
+		return MoveOrder{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			return MoveOrder{}, fmt.Errorf("error: %s is not a valid unit ID", id)
 		}
 		unitIDs = append(unitIDs, unitID)
 	}
 
-	newUnits := []Unit{}
 	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
-			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+			return MoveOrder{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+		if _, _, err := m.PathWithin(unit.Location, newLocation, MovementOf(unit.Rank)); err != nil {
+			return MoveOrder{}, fmt.Errorf("error: unit %v can't move: %w", unitID, err)
 		}
-		unit.Location = newLocation
-		gs.UpdateUnit(unit)
-		newUnits = append(newUnits, unit)
 	}
-
-	mv := ArmyMove{
+	return MoveOrder{
+		Player:     gs.GetUsername(),
 		ToLocation: newLocation,
-		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+		UnitIDs:    unitIDs,
+	}, nil
+}
+
+func formatPath(path []Location) string {
+	return joinLocations(path, " -> ")
+}
+
+func joinLocations(locs []Location, sep string) string {
+	names := make([]string, len(locs))
+	for i, loc := range locs {
+		names[i] = string(loc)
 	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	return strings.Join(names, sep)
 }
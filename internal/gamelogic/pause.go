@@ -2,18 +2,54 @@ package gamelogic
 
 import (
 	"fmt"
+	"time"
+)
+
+type TurnPhase string
 
-	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+const (
+	// PhaseOrders is while the server takes orders for the turn, until
+	// the deadline.
+	PhaseOrders TurnPhase = "orders"
+	// PhaseResolving is while the server carries the orders out.
+	PhaseResolving TurnPhase = "resolving"
+	// PhaseOver is once someone has won. The clock stops for good.
+	PhaseOver TurnPhase = "over"
 )
 
-func (gs *GameState) HandlePause(ps routing.PlayingState) {
+// PlayingState is where the game clock is. While the game is paused the
+// deadline is pushed back by however long the pause lasts.
+type PlayingState struct {
+	IsPaused bool
+	Turn     int
+	Phase    TurnPhase
+	Deadline time.Time
+}
+
+// HandlePause takes in where the game clock is, which the server announces
+// on pause, on resume and at the start of every turn.
+func (gs *GameState) HandlePause(ps PlayingState) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
-	if ps.IsPaused {
+	wasPaused := gs.setPlayingState(ps)
+	switch {
+	case ps.IsPaused && !wasPaused:
 		fmt.Println("==== Pause Detected ====")
-		gs.pauseGame()
-	} else {
+	case !ps.IsPaused && wasPaused:
 		fmt.Println("==== Resume Detected ====")
-		gs.resumeGame()
+	}
+	if ps.Phase == PhaseOver {
+		fmt.Printf("The game ended on turn %d.\n", ps.Turn)
+		return
+	}
+	if ps.IsPaused {
+		fmt.Printf("Turn %d is on hold.\n", ps.Turn)
+		return
+	}
+	switch ps.Phase {
+	case PhaseOrders:
+		fmt.Printf("Turn %d: orders are due by %s.\n", ps.Turn, ps.Deadline.Format(time.TimeOnly))
+	case PhaseResolving:
+		fmt.Printf("Turn %d is being resolved.\n", ps.Turn)
 	}
 }
@@ -5,30 +5,47 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+// CommandSpawn checks a spawn command and turns it into an order for the
+// server, which decides the unit's ID.
+func (gs *GameState) CommandSpawn(words []string) (SpawnOrder, error) {
+	if gs.isOver() {
+		return SpawnOrder{}, errors.New("the game is over, you can not spawn units")
+	}
+	if gs.isPaused() {
+		return SpawnOrder{}, errors.New("the game is paused, you can not spawn units")
+	}
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return SpawnOrder{}, errors.New("usage: spawn <location> <rank>")
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	if !gs.getMap().Has(Location(locationName)) {
+		return SpawnOrder{}, fmt.Errorf("error: %s is not a valid location", locationName)
+	}
+	economy := gs.getEconomy()
+	if !economy.Controls(Location(locationName)) {
+		return SpawnOrder{}, fmt.Errorf("error: you don't control %s", locationName)
 	}
 
 	rank := words[2]
 	units := getAllRanks()
 	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+		return SpawnOrder{}, fmt.Errorf("error: %s is not a valid unit", rank)
+	}
+	// The server also counts the spawns we've queued this turn.
+	if cost := CostOf(UnitRank(rank)).Cost; cost > economy.Treasury {
+		return SpawnOrder{}, fmt.Errorf("error: a(n) %s costs %d, and you have %d", rank, cost, economy.Treasury)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
+	return SpawnOrder{
+		Player:   gs.GetUsername(),
 		Location: Location(locationName),
-	})
+		Rank:     UnitRank(rank),
+	}, nil
+}
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+// HandleSpawn adds a unit the server has spawned for us.
+func (gs *GameState) HandleSpawn(u Unit) {
+	gs.addUnit(u)
+	fmt.Printf("Spawned a(n) %s in %s with id %v\n", u.Rank, u.Location, u.ID)
 }
@@ -0,0 +1,26 @@
+package gamelogic
+
+import "fmt"
+
+// HandleTurnResolved applies a turn in the order the server resolved it:
+// our new units, then everyone's moves, then the wars.
+func (gs *GameState) HandleTurnResolved(t TurnResolved) {
+	fmt.Println()
+	fmt.Printf("==== Turn %d Resolved ====\n", t.Turn)
+	if len(t.Spawns) == 0 && len(t.Moves) == 0 {
+		fmt.Println("Nothing happened.")
+	}
+	for _, u := range t.Spawns[gs.GetUsername()] {
+		gs.HandleSpawn(u)
+	}
+	for _, mv := range t.Moves {
+		gs.HandleMove(mv)
+	}
+	for _, r := range t.Wars {
+		gs.HandleWarResult(r)
+	}
+	if e, ok := t.Economies[gs.GetUsername()]; ok {
+		gs.SetEconomy(e)
+		fmt.Printf("Your treasury: %d (+%d income, -%d upkeep)\n", e.Treasury, e.Income, e.Upkeep)
+	}
+}
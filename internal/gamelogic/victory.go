@@ -0,0 +1,181 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VictoryConditions say how a game is won. A zero field turns its
+// condition off. They're checked at the end of every turn, in the order
+// listed, and the first that is met ends the game.
+type VictoryConditions struct {
+	// Territories wins the game for whoever controls at least this many
+	// territories, or the most of them if several players do.
+	Territories int
+	// CapitalTurns wins the game for whoever has held another player's
+	// capital, the territory they started in, for this many turns.
+	CapitalTurns int
+	// Elimination wins the game for the last player left with units or
+	// territories, once at least two have joined.
+	Elimination bool
+	// TurnLimit ends the game after this turn, and the highest score wins.
+	TurnLimit int
+}
+
+// DefaultVictoryConditions plays until one player is left.
+func DefaultVictoryConditions() VictoryConditions {
+	return VictoryConditions{Elimination: true}
+}
+
+// GameOver is how the game ended. Winners has more than one player on a
+// tie. Scores count territoryScore for each territory a player controls
+// plus the power of their units.
+type GameOver struct {
+	Turn    int
+	Winners []string
+	Reason  string
+	Scores  map[string]int
+}
+
+const territoryScore = 5
+
+// WithVictory ends the game when one of v is met instead of
+// DefaultVictoryConditions.
+func WithVictory(v VictoryConditions) WorldOption {
+	return func(w *World) {
+		w.victory = v
+	}
+}
+
+// GameOver returns how the game ended, or nil if it hasn't.
+func (w *World) GameOver() *GameOver {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.over
+}
+
+// checkVictory works out whether the turn that has just been resolved ended
+// the game.
+func (w *World) checkVictory() *GameOver {
+	scores := map[string]int{}
+	territories := map[string]int{}
+	for _, name := range w.sortedPlayers() {
+		territories[name] = len(w.economy(name).Territories)
+		scores[name] = territories[name]*territoryScore + unitsToPowerLevel(unitsOf(*w.players[name]))
+	}
+	over := func(winners []string, reason string) *GameOver {
+		return &GameOver{Turn: w.turn, Winners: winners, Reason: reason, Scores: scores}
+	}
+
+	v := w.victory
+	if v.Territories > 0 {
+		if winners := best(territories, v.Territories); len(winners) > 0 {
+			return over(winners, fmt.Sprintf("control %d territories", territories[winners[0]]))
+		}
+	}
+	if v.CapitalTurns > 0 {
+		var winners []string
+		for _, name := range w.sortedPlayers() {
+			for _, capital := range w.capitalsHeldBy(name) {
+				if w.turn-w.controlSince[capital]+1 >= v.CapitalTurns {
+					winners = append(winners, name)
+					break
+				}
+			}
+		}
+		if len(winners) > 0 {
+			return over(winners, fmt.Sprintf("held an enemy capital for %d turns", v.CapitalTurns))
+		}
+	}
+	if v.Elimination && len(w.players) > 1 {
+		var alive []string
+		for _, name := range w.sortedPlayers() {
+			if len(w.players[name].Units) > 0 || territories[name] > 0 {
+				alive = append(alive, name)
+			}
+		}
+		if len(alive) <= 1 {
+			return over(alive, "eliminated every opponent")
+		}
+	}
+	if v.TurnLimit > 0 && w.turn >= v.TurnLimit {
+		return over(best(scores, 0), fmt.Sprintf("had the highest score after %d turns", v.TurnLimit))
+	}
+	return nil
+}
+
+// capitalsHeldBy returns the other players' capitals that name controls.
+func (w *World) capitalsHeldBy(name string) []Location {
+	var held []Location
+	for owner, capital := range w.home {
+		if owner != name && w.control[capital] == name {
+			held = append(held, capital)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+	return held
+}
+
+// best returns the players with the highest count, if it is at least
+// least, in username order.
+func best(counts map[string]int, least int) []string {
+	top := least
+	var names []string
+	for name, n := range counts {
+		switch {
+		case n > top:
+			top = n
+			names = []string{name}
+		case n == top:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unitsOf(p Player) []Unit {
+	units := make([]Unit, 0, len(p.Units))
+	for _, u := range p.Units {
+		units = append(units, u)
+	}
+	return units
+}
+
+// HandleGameOver shows how the game ended. No orders are taken after it.
+func (gs *GameState) HandleGameOver(g GameOver) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	gs.Phase = PhaseOver
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	switch len(g.Winners) {
+	case 0:
+		fmt.Printf("Nobody won on turn %d.\n", g.Turn)
+	case 1:
+		if g.Winners[0] == gs.GetUsername() {
+			fmt.Printf("You won on turn %d: you %s!\n", g.Turn, g.Reason)
+		} else {
+			fmt.Printf("%s won on turn %d: they %s.\n", g.Winners[0], g.Turn, g.Reason)
+		}
+	default:
+		fmt.Printf("%s tied on turn %d: they each %s.\n", strings.Join(g.Winners, " and "), g.Turn, g.Reason)
+	}
+	names := make([]string, 0, len(g.Scores))
+	for name := range g.Scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if g.Scores[names[i]] != g.Scores[names[j]] {
+			return g.Scores[names[i]] > g.Scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Println("Scores:")
+	for _, name := range names {
+		fmt.Printf("* %s: %d\n", name, g.Scores[name])
+	}
+}
@@ -0,0 +1,105 @@
+package gamelogic
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// playUntilOver joins alice (americas) and bob (europe). On turn 1 alice
+// spawns a cavalry and bob an infantry at home, or nothing if bobUnits is
+// false. On turn 2 alice's cavalry moves into europe if attack is set.
+// Turns are then resolved until the game ends or maxTurns is reached.
+func playUntilOver(t *testing.T, v VictoryConditions, bobUnits, attack bool, maxTurns int) (*World, *GameOver) {
+	t.Helper()
+	w := NewWorld(WithVictory(v))
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := w.Join(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: "americas", Rank: RankCavalry}); err != nil {
+		t.Fatal(err)
+	}
+	if bobUnits {
+		if _, err := w.QueueSpawn(SpawnOrder{Player: "bob", Location: "europe", Rank: RankInfantry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for w.GameOver() == nil && w.Turn() <= maxTurns {
+		if w.Turn() == 2 && attack {
+			if _, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: "europe", UnitIDs: []int{1}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := w.ResolveTurn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, w.GameOver()
+}
+
+func TestVictoryConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        VictoryConditions
+		bobUnits bool
+		attack   bool
+		turn     int // 0 if the game shouldn't end within 5 turns
+		winners  []string
+	}{
+		{name: "territories", v: VictoryConditions{Territories: 2}, attack: true, turn: 2, winners: []string{"alice"}},
+		{name: "territory tie", v: VictoryConditions{Territories: 1}, turn: 1, winners: []string{"alice", "bob"}},
+		{name: "capital held", v: VictoryConditions{CapitalTurns: 2}, attack: true, turn: 3, winners: []string{"alice"}},
+		{name: "elimination", v: DefaultVictoryConditions(), attack: true, turn: 2, winners: []string{"alice"}},
+		{name: "nobody eliminated", v: DefaultVictoryConditions(), bobUnits: true},
+		{name: "turn limit", v: VictoryConditions{TurnLimit: 3}, bobUnits: true, turn: 3, winners: []string{"alice"}},
+		{name: "no conditions", v: VictoryConditions{}, attack: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, over := playUntilOver(t, tt.v, tt.bobUnits, tt.attack, 5)
+			if tt.turn == 0 {
+				if over != nil {
+					t.Fatalf("game ended: %+v", over)
+				}
+				return
+			}
+			if over == nil {
+				t.Fatal("game never ended")
+			}
+			if over.Turn != tt.turn || !reflect.DeepEqual(over.Winners, tt.winners) {
+				t.Fatalf("game over = turn %d, winners %v; want turn %d, winners %v",
+					over.Turn, over.Winners, tt.turn, tt.winners)
+			}
+		})
+	}
+}
+
+func TestGameOverScores(t *testing.T) {
+	_, over := playUntilOver(t, VictoryConditions{TurnLimit: 1}, true, false, 1)
+	if over == nil {
+		t.Fatal("game never ended")
+	}
+	alice := territoryScore + unitsToPowerLevel([]Unit{{Rank: RankCavalry}})
+	bob := territoryScore + unitsToPowerLevel([]Unit{{Rank: RankInfantry}})
+	if want := map[string]int{"alice": alice, "bob": bob}; !reflect.DeepEqual(over.Scores, want) {
+		t.Fatalf("Scores = %v, want %v", over.Scores, want)
+	}
+}
+
+func TestNoOrdersAfterGameOver(t *testing.T) {
+	w, over := playUntilOver(t, VictoryConditions{TurnLimit: 1}, false, false, 1)
+	if over == nil {
+		t.Fatal("game never ended")
+	}
+	if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: "americas", Rank: RankInfantry}); !errors.Is(err, ErrGameOver) {
+		t.Fatalf("QueueSpawn = %v, want ErrGameOver", err)
+	}
+	if _, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: "europe", UnitIDs: []int{1}}); !errors.Is(err, ErrGameOver) {
+		t.Fatalf("QueueMove = %v, want ErrGameOver", err)
+	}
+	if _, err := w.ResolveTurn(); !errors.Is(err, ErrGameOver) {
+		t.Fatalf("ResolveTurn = %v, want ErrGameOver", err)
+	}
+}
@@ -14,78 +14,52 @@ const (
 	WarOutcomeDraw
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
+// HandleWarResult reports a war the server has resolved and removes our
+// units that died in it.
+func (gs *GameState) HandleWarResult(r WarResult) WarOutcome {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
-
-	player := gs.GetPlayerSnap()
-
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	fmt.Printf("%s has declared war on %s in %s!\n", r.Attacker, r.Defender, r.Location)
+	fmt.Printf("%s's units:\n", r.Attacker)
+	for _, unit := range r.AttackerUnits {
+		fmt.Printf("  * %v\n", unit.Rank)
 	}
-
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	fmt.Printf("%s's units:\n", r.Defender)
+	for _, unit := range r.DefenderUnits {
+		fmt.Printf("  * %v\n", unit.Rank)
 	}
-
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+	for _, line := range r.Report {
+		fmt.Printf("  %s\n", line)
 	}
-
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
-	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
-		}
+	fmt.Printf("Attacker has a power level of %v\n", r.AttackerPower)
+	fmt.Printf("Defender has a power level of %v\n", r.DefenderPower)
+	if r.Winner != "" {
+		fmt.Printf("%s has won the war!\n", r.Winner)
+	} else {
+		fmt.Println("The war ended in a draw!")
 	}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	username := gs.GetUsername()
+	if username != r.Attacker && username != r.Defender {
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		return WarOutcomeNotInvolved
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	for _, id := range r.Casualties[username] {
+		gs.removeUnit(id)
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+	if n := len(r.Casualties[username]); n > 0 {
+		fmt.Printf("Your %d unit(s) in %s have been killed.\n", n, r.Location)
+	}
+	switch r.Winner {
+	case "":
+		return WarOutcomeDraw
+	case username:
+		return WarOutcomeYouWon
+	default:
+		fmt.Println("You have lost the war!")
+		return WarOutcomeOpponentWon
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
 }
 
 func unitsToPowerLevel(units []Unit) int {
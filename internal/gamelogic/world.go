@@ -0,0 +1,579 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrGamePaused    = errors.New("the game is paused")
+	ErrGameOver      = errors.New("the game is over")
+	ErrUnknownPlayer = errors.New("unknown player")
+)
+
+// World is the server's canonical state of every player's units. Clients
+// only send orders; the World checks them and holds them until the turn is
+// resolved, all at once.
+//
+// Every change is made by recording an Event. A World opened on a
+// WorldStore journals each event before applying it, and can be restored
+// from the store's snapshot and the events since.
+type World struct {
+	mu      sync.Mutex
+	store   WorldStore
+	seq     uint64
+	paused  bool
+	turn    int
+	players map[string]*Player
+	nextID  map[string]int
+	m       WorldMap
+
+	treasury map[string]int
+	// control maps each territory to the player who holds it: the last to
+	// have units there alone, or whoever started there.
+	control      map[Location]string
+	controlSince map[Location]int
+	// home is where each player started, which is their capital.
+	home    map[string]Location
+	victory VictoryConditions
+	over    *GameOver
+	combat  CombatResolver
+
+	// The orders for this turn. They aren't journaled, so orders are lost
+	// if the server stops before the turn is resolved.
+	spawns  []SpawnOrder
+	moves   []MoveOrder
+	ordered map[string]map[int]bool
+}
+
+type WorldOption func(*World)
+
+// WithMap plays on m instead of DefaultWorldMap.
+func WithMap(m WorldMap) WorldOption {
+	return func(w *World) {
+		w.m = m
+	}
+}
+
+func NewWorld(opts ...WorldOption) *World {
+	w := &World{
+		players:  map[string]*Player{},
+		nextID:   map[string]int{},
+		turn:     1,
+		m:        DefaultWorldMap(),
+		treasury: map[string]int{},
+		control:  map[Location]string{},
+		ordered:  map[string]map[int]bool{},
+
+		controlSince: map[Location]int{},
+		home:         map[string]Location{},
+		victory:      DefaultVictoryConditions(),
+		combat:       PowerResolver{},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// OpenWorld restores the World kept in store, or starts an empty one, and
+// journals every change to it from then on.
+func OpenWorld(store WorldStore, opts ...WorldOption) (*World, error) {
+	w := NewWorld(opts...)
+	snap, ok, err := store.LoadSnapshot()
+	if err != nil {
+		return nil, fmt.Errorf("loading snapshot: %w", err)
+	}
+	if ok {
+		w.restore(snap)
+	}
+	events, err := store.Events(w.seq)
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	for _, e := range events {
+		w.apply(e)
+	}
+	w.store = store
+	return w, nil
+}
+
+// Snapshot returns a copy of the whole World.
+func (w *World) Snapshot() Snapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshot()
+}
+
+func (w *World) snapshot() Snapshot {
+	snap := Snapshot{
+		Seq:      w.seq,
+		Time:     time.Now(),
+		Turn:     w.turn,
+		Paused:   w.paused,
+		Players:  make([]Player, 0, len(w.players)),
+		NextID:   make(map[string]int, len(w.nextID)),
+		Treasury: make(map[string]int, len(w.treasury)),
+		Control:  make(map[Location]string, len(w.control)),
+
+		ControlSince: make(map[Location]int, len(w.controlSince)),
+		Home:         make(map[string]Location, len(w.home)),
+		GameOver:     w.over,
+	}
+	for _, name := range w.sortedPlayers() {
+		snap.Players = append(snap.Players, copyPlayer(*w.players[name]))
+	}
+	for name, id := range w.nextID {
+		snap.NextID[name] = id
+	}
+	for name, gold := range w.treasury {
+		snap.Treasury[name] = gold
+	}
+	for loc, name := range w.control {
+		snap.Control[loc] = name
+	}
+	for loc, turn := range w.controlSince {
+		snap.ControlSince[loc] = turn
+	}
+	for name, loc := range w.home {
+		snap.Home[name] = loc
+	}
+	return snap
+}
+
+// SaveSnapshot saves a snapshot to the World's store, which can then drop
+// the journal up to it. It does nothing for a World without a store.
+func (w *World) SaveSnapshot() error {
+	if w.store == nil {
+		return nil
+	}
+	return w.store.SaveSnapshot(w.Snapshot())
+}
+
+func (w *World) restore(snap Snapshot) {
+	w.seq = snap.Seq
+	w.turn = max(snap.Turn, 1)
+	w.paused = snap.Paused
+	for _, p := range snap.Players {
+		p := copyPlayer(p)
+		w.players[p.Username] = &p
+	}
+	for name, id := range snap.NextID {
+		w.nextID[name] = id
+	}
+	for name, gold := range snap.Treasury {
+		w.treasury[name] = gold
+	}
+	for loc, name := range snap.Control {
+		w.control[loc] = name
+	}
+	for loc, turn := range snap.ControlSince {
+		w.controlSince[loc] = turn
+	}
+	for name, loc := range snap.Home {
+		w.home[name] = loc
+	}
+	w.over = snap.GameOver
+}
+
+// record journals e, if the World has a store, and applies it. Nothing
+// changes if e can't be journaled.
+func (w *World) record(e Event) error {
+	e.Seq = w.seq + 1
+	e.Time = time.Now()
+	if w.store != nil {
+		if err := w.store.Append(e); err != nil {
+			return fmt.Errorf("saving %s: %w", e.Kind, err)
+		}
+	}
+	w.apply(e)
+	return nil
+}
+
+func (w *World) apply(e Event) {
+	w.seq = e.Seq
+	switch e.Kind {
+	case EventJoin:
+		if _, ok := w.players[e.Player]; !ok {
+			w.players[e.Player] = &Player{Username: e.Player, Units: map[int]Unit{}}
+			w.nextID[e.Player] = max(w.nextID[e.Player], 1)
+			w.treasury[e.Player] = e.Treasury
+			if e.Territory != "" {
+				w.control[e.Territory] = e.Player
+				w.controlSince[e.Territory] = w.turn
+				w.home[e.Player] = e.Territory
+			}
+		}
+	case EventSpawn:
+		if p, ok := w.players[e.Player]; ok && e.Unit != nil {
+			p.Units[e.Unit.ID] = *e.Unit
+			w.nextID[e.Player] = max(w.nextID[e.Player], e.Unit.ID+1)
+			w.treasury[e.Player] = e.Treasury
+		}
+	case EventMove:
+		if p, ok := w.players[e.Player]; ok && e.Move != nil {
+			for _, u := range e.Move.Units {
+				p.Units[u.ID] = u
+			}
+		}
+	case EventWar:
+		if e.War == nil {
+			return
+		}
+		for name, ids := range e.War.Casualties {
+			if p, ok := w.players[name]; ok {
+				for _, id := range ids {
+					delete(p.Units, id)
+				}
+			}
+		}
+	case EventPause:
+		w.paused = e.Paused
+	case EventTurn:
+		w.turn = e.Turn
+	case EventIncome:
+		w.treasury[e.Player] = e.Treasury
+	case EventControl:
+		w.control[e.Territory] = e.Player
+		w.controlSince[e.Territory] = w.turn
+	case EventGameOver:
+		w.over = e.GameOver
+	}
+}
+
+// Join adds a player, if they aren't in the world already, and returns
+// their units. A new player starts with StartingTreasury and the first
+// territory on the map that nobody holds.
+func (w *World) Join(username string) (Player, error) {
+	if username == "" {
+		return Player{}, errors.New("a username is required")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.players[username]; !ok {
+		home, ok := w.freeTerritory()
+		if !ok {
+			return Player{}, errors.New("there is no free territory left to start in")
+		}
+		e := Event{Kind: EventJoin, Player: username, Territory: home, Treasury: StartingTreasury}
+		if err := w.record(e); err != nil {
+			return Player{}, err
+		}
+	}
+	return copyPlayer(*w.players[username]), nil
+}
+
+// Map returns the map the World is played on.
+func (w *World) Map() WorldMap {
+	return w.m
+}
+
+func (w *World) SetPaused(paused bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.record(Event{Kind: EventPause, Paused: paused})
+}
+
+func (w *World) Paused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+// Players returns a copy of every player, sorted by username.
+func (w *World) Players() []Player {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	players := make([]Player, 0, len(w.players))
+	for _, p := range w.players {
+		players = append(players, copyPlayer(*p))
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Username < players[j].Username })
+	return players
+}
+
+// Turn returns the number of the turn orders are being taken for.
+func (w *World) Turn() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.turn
+}
+
+// QueueSpawn checks a spawn order and holds it until the turn is resolved.
+// Players can only spawn in territories they control, and only what they
+// can pay for after the spawns they've already queued. It returns the turn
+// the order is for.
+func (w *World) QueueSpawn(o SpawnOrder) (int, error) {
+	if !w.m.Has(o.Location) {
+		return 0, fmt.Errorf("%s is not a valid location", o.Location)
+	}
+	if _, ok := getAllRanks()[o.Rank]; !ok {
+		return 0, fmt.Errorf("%s is not a valid unit", o.Rank)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over != nil {
+		return 0, ErrGameOver
+	}
+	if w.paused {
+		return 0, ErrGamePaused
+	}
+	if _, ok := w.players[o.Player]; !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownPlayer, o.Player)
+	}
+	if err := w.checkSpawn(o, w.pendingCost(o.Player)); err != nil {
+		return 0, err
+	}
+	w.spawns = append(w.spawns, o)
+	return w.turn, nil
+}
+
+// QueueMove checks a move order and holds it until the turn is resolved.
+// Each unit takes one order a turn. It returns the turn the order is for.
+func (w *World) QueueMove(o MoveOrder) (int, error) {
+	if !w.m.Has(o.ToLocation) {
+		return 0, fmt.Errorf("%s is not a valid location", o.ToLocation)
+	}
+	if len(o.UnitIDs) == 0 {
+		return 0, errors.New("no units to move")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over != nil {
+		return 0, ErrGameOver
+	}
+	if w.paused {
+		return 0, ErrGamePaused
+	}
+	p, ok := w.players[o.Player]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrUnknownPlayer, o.Player)
+	}
+	seen := map[int]bool{}
+	for _, id := range o.UnitIDs {
+		u, ok := p.Units[id]
+		if !ok {
+			return 0, fmt.Errorf("unit with ID %v not found", id)
+		}
+		if seen[id] {
+			return 0, fmt.Errorf("unit with ID %v given twice", id)
+		}
+		if w.ordered[o.Player][id] {
+			return 0, fmt.Errorf("unit with ID %v already has orders this turn", id)
+		}
+		if _, _, err := w.m.PathWithin(u.Location, o.ToLocation, MovementOf(u.Rank)); err != nil {
+			return 0, fmt.Errorf("unit %v can't move: %w", id, err)
+		}
+		seen[id] = true
+	}
+	if w.ordered[o.Player] == nil {
+		w.ordered[o.Player] = map[int]bool{}
+	}
+	for id := range seen {
+		w.ordered[o.Player][id] = true
+	}
+	w.moves = append(w.moves, o)
+	return w.turn, nil
+}
+
+// ResolveTurn carries out the turn's orders as if at once: first every
+// spawn, then every move, each unit along the cheapest path from where it
+// was if it can travel that far, and only then the wars. A war is fought
+// for each move whose units arrive where another player has units, against
+// each such player in username order. Moves are taken in username order
+// too, so the outcome doesn't depend on who ordered first. Then players
+// take the territories they hold alone, and collect their income less
+// their upkeep. Last, the turn ends the game if it met one of the victory
+// conditions, and otherwise moves on to the next.
+//
+// The turn is resolved on a copy of the World and its events journaled all
+// together, so if they can't be nothing changes: the orders are kept and
+// the turn can be resolved again.
+func (w *World) ResolveTurn() (TurnResolved, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over != nil {
+		return TurnResolved{Turn: w.turn}, ErrGameOver
+	}
+	scratch := w.scratch()
+	t, err := scratch.resolveTurn()
+	if err != nil {
+		return TurnResolved{Turn: w.turn}, err
+	}
+	events, err := scratch.store.Events(w.seq)
+	if err != nil {
+		return TurnResolved{Turn: w.turn}, err
+	}
+	if w.store != nil {
+		if err := w.store.Append(events...); err != nil {
+			return TurnResolved{Turn: w.turn}, fmt.Errorf("saving turn %d: %w", w.turn, err)
+		}
+	}
+	for _, e := range events {
+		w.apply(e)
+	}
+	w.spawns, w.moves, w.ordered = nil, nil, map[string]map[int]bool{}
+	return t, nil
+}
+
+// scratch copies the World and its orders, journaling to memory.
+func (w *World) scratch() *World {
+	s := NewWorld(WithMap(w.m), WithVictory(w.victory), WithCombat(w.combat))
+	s.store = NewMemoryWorldStore()
+	s.restore(w.snapshot())
+	s.spawns = append([]SpawnOrder(nil), w.spawns...)
+	s.moves = append([]MoveOrder(nil), w.moves...)
+	return s
+}
+
+func (w *World) resolveTurn() (TurnResolved, error) {
+	t := TurnResolved{Turn: w.turn, Spawns: map[string][]Unit{}}
+	spawns, moves := w.spawns, w.moves
+
+	for _, o := range spawns {
+		if err := w.checkSpawn(o, 0); err != nil {
+			continue
+		}
+		u := Unit{ID: w.nextID[o.Player], Rank: o.Rank, Location: o.Location}
+		e := Event{
+			Kind:     EventSpawn,
+			Player:   o.Player,
+			Unit:     &u,
+			Treasury: w.treasury[o.Player] - CostOf(o.Rank).Cost,
+		}
+		if err := w.record(e); err != nil {
+			return t, err
+		}
+		t.Spawns[o.Player] = append(t.Spawns[o.Player], u)
+	}
+
+	sort.SliceStable(moves, func(i, j int) bool { return moves[i].Player < moves[j].Player })
+	for _, o := range moves {
+		mv, ok := w.planMove(o)
+		if !ok {
+			continue
+		}
+		if err := w.record(Event{Kind: EventMove, Player: o.Player, Move: &mv}); err != nil {
+			return t, err
+		}
+		t.Moves = append(t.Moves, mv)
+	}
+
+	for _, mv := range t.Moves {
+		attacker := w.players[mv.Player.Username]
+		for _, name := range w.sortedPlayers() {
+			if name == attacker.Username {
+				continue
+			}
+			if len(unitsIn(*attacker, mv.ToLocation)) == 0 {
+				break
+			}
+			defender := w.players[name]
+			if len(unitsIn(*defender, mv.ToLocation)) == 0 {
+				continue
+			}
+			war := w.combat.Resolve(Battle{
+				Turn:          w.turn,
+				Location:      mv.ToLocation,
+				Terrain:       w.m.TerrainOf(mv.ToLocation),
+				Attacker:      attacker.Username,
+				Defender:      defender.Username,
+				AttackerUnits: unitsIn(*attacker, mv.ToLocation),
+				DefenderUnits: unitsIn(*defender, mv.ToLocation),
+			})
+			if err := w.record(Event{Kind: EventWar, Player: attacker.Username, War: &war}); err != nil {
+				return t, err
+			}
+			t.Wars = append(t.Wars, war)
+		}
+	}
+
+	occupants := w.occupants()
+	for _, terr := range w.m.Territories {
+		names := occupants[terr.Name]
+		if len(names) != 1 || w.control[terr.Name] == names[0] {
+			continue
+		}
+		if err := w.record(Event{Kind: EventControl, Player: names[0], Territory: terr.Name}); err != nil {
+			return t, err
+		}
+	}
+	for _, name := range w.sortedPlayers() {
+		e := w.economy(name)
+		treasury := max(e.Treasury+e.Income-e.Upkeep, 0)
+		if err := w.record(Event{Kind: EventIncome, Player: name, Treasury: treasury}); err != nil {
+			return t, err
+		}
+	}
+	t.Economies = map[string]Economy{}
+	for _, name := range w.sortedPlayers() {
+		t.Economies[name] = w.economy(name)
+	}
+
+	if over := w.checkVictory(); over != nil {
+		return t, w.record(Event{Kind: EventGameOver, GameOver: over})
+	}
+	if err := w.record(Event{Kind: EventTurn, Turn: w.turn + 1}); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// planMove works out where a queued move takes its units. The move reports
+// only the units that moved, not the rest of the player's army.
+func (w *World) planMove(o MoveOrder) (ArmyMove, bool) {
+	p, ok := w.players[o.Player]
+	if !ok {
+		return ArmyMove{}, false
+	}
+	mv := ArmyMove{
+		Player:     Player{Username: p.Username},
+		ToLocation: o.ToLocation,
+		Paths:      map[int][]Location{},
+	}
+	for _, id := range o.UnitIDs {
+		u, ok := p.Units[id]
+		if !ok {
+			continue
+		}
+		path, _, err := w.m.PathWithin(u.Location, o.ToLocation, MovementOf(u.Rank))
+		if err != nil {
+			continue
+		}
+		u.Location = o.ToLocation
+		mv.Units = append(mv.Units, u)
+		mv.Paths[id] = path
+	}
+	return mv, len(mv.Units) > 0
+}
+
+func (w *World) sortedPlayers() []string {
+	names := make([]string, 0, len(w.players))
+	for name := range w.players {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unitsIn(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, u := range p.Units {
+		if u.Location == loc {
+			units = append(units, u)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units
+}
+
+func copyPlayer(p Player) Player {
+	units := make(map[int]Unit, len(p.Units))
+	for id, u := range p.Units {
+		units[id] = u
+	}
+	return Player{Username: p.Username, Units: units}
+}
@@ -0,0 +1,153 @@
+package gamelogic
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestWorld joins alice, who starts in the first territory of m, and
+// gives alice one infantry and one cavalry there.
+func newTestWorld(t *testing.T, m WorldMap) *World {
+	t.Helper()
+	w := NewWorld(WithMap(m))
+	if _, err := w.Join("alice"); err != nil {
+		t.Fatal(err)
+	}
+	home := m.Territories[0].Name
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry} {
+		if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: home, Rank: rank}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.ResolveTurn(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestQueueMoveBudget(t *testing.T) {
+	m := DefaultWorldMap()
+	m.Territories = append(m.Territories, Territory{Name: "atlantis"})
+
+	tests := []struct {
+		name string
+		to   Location
+		unit int // 1 is infantry, 2 cavalry
+		want error
+	}{
+		{name: "adjacent", to: "europe", unit: 1},
+		{name: "two edges within budget", to: "africa", unit: 2},
+		// americas -> antarctica -> australia costs 4.
+		{name: "over budget", to: "australia", unit: 2, want: ErrTooFar},
+		{name: "infantry over budget", to: "africa", unit: 1, want: ErrTooFar},
+		{name: "unreachable", to: "atlantis", unit: 1, want: errAny},
+		{name: "unknown location", to: "narnia", unit: 1, want: errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWorld(t, m)
+			_, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: tt.to, UnitIDs: []int{tt.unit}})
+			switch {
+			case tt.want == nil && err != nil:
+				t.Fatalf("QueueMove: %v", err)
+			case tt.want == errAny && err == nil,
+				tt.want != nil && tt.want != errAny && !errors.Is(err, tt.want):
+				t.Fatalf("QueueMove = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+var errAny = errors.New("any error")
+
+func TestResolveTurnMovesWithinBudget(t *testing.T) {
+	w := newTestWorld(t, DefaultWorldMap())
+	if _, err := w.QueueMove(MoveOrder{Player: "alice", ToLocation: "africa", UnitIDs: []int{2}}); err != nil {
+		t.Fatal(err)
+	}
+	turn, err := w.ResolveTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(turn.Moves) != 1 {
+		t.Fatalf("got %d moves, want 1", len(turn.Moves))
+	}
+	path := turn.Moves[0].Paths[2]
+	cost, err := DefaultWorldMap().CheckPath(path)
+	if err != nil || cost > MovementOf(RankCavalry) {
+		t.Fatalf("path %v costs %d (%v)", path, cost, err)
+	}
+}
+
+func TestPathWithin(t *testing.T) {
+	m := DefaultWorldMap()
+	if _, cost, err := m.PathWithin("europe", "asia", 1); err != nil || cost != 1 {
+		t.Fatalf("PathWithin(europe, asia, 1) = %d, %v", cost, err)
+	}
+	if _, _, err := m.PathWithin("americas", "australia", 3); !errors.Is(err, ErrTooFar) {
+		t.Fatalf("PathWithin(americas, australia, 3) = %v, want ErrTooFar", err)
+	}
+}
+
+// flakyStore fails every append while failing is set.
+type flakyStore struct {
+	*MemoryWorldStore
+	failing bool
+}
+
+func (s *flakyStore) Append(events ...Event) error {
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.MemoryWorldStore.Append(events...)
+}
+
+func TestResolveTurnIsAtomic(t *testing.T) {
+	store := &flakyStore{MemoryWorldStore: NewMemoryWorldStore()}
+	w, err := OpenWorld(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Join("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.QueueSpawn(SpawnOrder{Player: "alice", Location: "americas", Rank: RankInfantry}); err != nil {
+		t.Fatal(err)
+	}
+	before := w.Snapshot()
+
+	store.failing = true
+	if _, err := w.ResolveTurn(); err == nil {
+		t.Fatal("ResolveTurn succeeded without journaling")
+	}
+	after := w.Snapshot()
+	before.Time, after.Time = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("a failed turn changed the world:\n%+v\n%+v", before, after)
+	}
+
+	store.failing = false
+	turn, err := w.ResolveTurn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn.Turn != 1 || len(turn.Spawns["alice"]) != 1 {
+		t.Fatalf("the queued spawn was lost: %+v", turn)
+	}
+	if w.Turn() != 2 {
+		t.Fatalf("turn is %d, want 2", w.Turn())
+	}
+
+	// The journal replays to the same world.
+	reopened, err := OpenWorld(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := reopened.Snapshot(), w.Snapshot()
+	got.Time, want.Time = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed world differs:\n%+v\n%+v", got, want)
+	}
+}
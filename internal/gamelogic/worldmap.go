@@ -0,0 +1,274 @@
+package gamelogic
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrTooFar is returned for a move that costs more than a unit can travel
+// in one turn.
+var ErrTooFar = errors.New("too far to move in one turn")
+
+// WorldMap is the board: territories, and the edges units travel along
+// between them. Edges go both ways. A move's cost is the sum of the costs
+// of the edges it takes.
+type WorldMap struct {
+	Territories []Territory `json:"territories" yaml:"territories"`
+	Edges       []Edge      `json:"edges" yaml:"edges"`
+}
+
+// Territory is a place on the map. Whoever controls it collects its income
+// every turn. Its terrain changes how battles there go, if the game's
+// CombatResolver takes it into account.
+type Territory struct {
+	Name    Location `json:"name" yaml:"name"`
+	Income  int      `json:"income,omitempty" yaml:"income,omitempty"`
+	Terrain Terrain  `json:"terrain,omitempty" yaml:"terrain,omitempty"`
+}
+
+type Edge struct {
+	From Location `json:"from" yaml:"from"`
+	To   Location `json:"to" yaml:"to"`
+	Cost int      `json:"cost" yaml:"cost"`
+}
+
+// DefaultWorldMap is the six continents, joined across the narrower seas.
+func DefaultWorldMap() WorldMap {
+	return WorldMap{
+		Territories: []Territory{
+			{Name: "americas", Income: 3},
+			{Name: "europe", Income: 3, Terrain: TerrainForest},
+			{Name: "africa", Income: 2},
+			{Name: "asia", Income: 3, Terrain: TerrainMountains},
+			{Name: "australia", Income: 2},
+			{Name: "antarctica", Income: 1, Terrain: TerrainIce},
+		},
+		Edges: []Edge{
+			{From: "americas", To: "europe", Cost: 2},
+			{From: "americas", To: "asia", Cost: 2},
+			{From: "americas", To: "antarctica", Cost: 2},
+			{From: "europe", To: "africa", Cost: 1},
+			{From: "europe", To: "asia", Cost: 1},
+			{From: "africa", To: "asia", Cost: 1},
+			{From: "africa", To: "antarctica", Cost: 3},
+			{From: "asia", To: "australia", Cost: 2},
+			{From: "australia", To: "antarctica", Cost: 2},
+		},
+	}
+}
+
+// LoadWorldMap reads a map from a YAML (.yaml, .yml) or JSON file.
+func LoadWorldMap(path string) (WorldMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return WorldMap{}, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseWorldMapYAML(data)
+	default:
+		return ParseWorldMapJSON(data)
+	}
+}
+
+func ParseWorldMapJSON(data []byte) (WorldMap, error) {
+	var m WorldMap
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return WorldMap{}, fmt.Errorf("parsing map: %w", err)
+	}
+	return m, m.Validate()
+}
+
+func ParseWorldMapYAML(data []byte) (WorldMap, error) {
+	var m WorldMap
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return WorldMap{}, fmt.Errorf("parsing map: %w", err)
+	}
+	return m, m.Validate()
+}
+
+// Validate checks that territories are named once each, with no negative
+// income and a known terrain, and that edges join two different known
+// territories at a positive cost.
+func (m WorldMap) Validate() error {
+	if len(m.Territories) == 0 {
+		return errors.New("map has no territories")
+	}
+	seen := map[Location]bool{}
+	for i, t := range m.Territories {
+		if t.Name == "" {
+			return fmt.Errorf("territory %d has no name", i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("territory %s is listed twice", t.Name)
+		}
+		if t.Income < 0 {
+			return fmt.Errorf("territory %s has negative income", t.Name)
+		}
+		if _, ok := terrainRules[t.Terrain]; t.Terrain != "" && !ok {
+			return fmt.Errorf("territory %s has unknown terrain %q", t.Name, t.Terrain)
+		}
+		seen[t.Name] = true
+	}
+	for _, e := range m.Edges {
+		if !seen[e.From] || !seen[e.To] {
+			return fmt.Errorf("edge %s-%s joins an unknown territory", e.From, e.To)
+		}
+		if e.From == e.To {
+			return fmt.Errorf("edge %s-%s joins a territory to itself", e.From, e.To)
+		}
+		if e.Cost <= 0 {
+			return fmt.Errorf("edge %s-%s must cost at least 1", e.From, e.To)
+		}
+	}
+	return nil
+}
+
+func (m WorldMap) Has(loc Location) bool {
+	for _, t := range m.Territories {
+		if t.Name == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// TerrainOf returns loc's terrain, which is plains unless the map says
+// otherwise.
+func (m WorldMap) TerrainOf(loc Location) Terrain {
+	for _, t := range m.Territories {
+		if t.Name == loc && t.Terrain != "" {
+			return t.Terrain
+		}
+	}
+	return TerrainPlains
+}
+
+// Neighbors returns the territories one edge away from loc, with the
+// cheapest edge's cost to each.
+func (m WorldMap) Neighbors(loc Location) map[Location]int {
+	out := map[Location]int{}
+	add := func(to Location, cost int) {
+		if c, ok := out[to]; !ok || cost < c {
+			out[to] = cost
+		}
+	}
+	for _, e := range m.Edges {
+		switch loc {
+		case e.From:
+			add(e.To, e.Cost)
+		case e.To:
+			add(e.From, e.Cost)
+		}
+	}
+	return out
+}
+
+// Path finds the cheapest way from one territory to another. The path
+// starts at from and ends at to, so a unit already there has a path of
+// one territory and no cost.
+func (m WorldMap) Path(from, to Location) ([]Location, int, error) {
+	for _, loc := range []Location{from, to} {
+		if !m.Has(loc) {
+			return nil, 0, fmt.Errorf("%s is not a valid location", loc)
+		}
+	}
+	dist := map[Location]int{from: 0}
+	prev := map[Location]Location{}
+	q := &pathQueue{{loc: from}}
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(pathStep)
+		if cur.cost > dist[cur.loc] {
+			continue
+		}
+		if cur.loc == to {
+			path := []Location{to}
+			for loc := to; loc != from; {
+				loc = prev[loc]
+				path = append([]Location{loc}, path...)
+			}
+			return path, cur.cost, nil
+		}
+		// Visit neighbors in order so ties always break the same way.
+		neighbors := m.Neighbors(cur.loc)
+		names := make([]Location, 0, len(neighbors))
+		for next := range neighbors {
+			names = append(names, next)
+		}
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+		for _, next := range names {
+			d := cur.cost + neighbors[next]
+			if best, ok := dist[next]; !ok || d < best {
+				dist[next] = d
+				prev[next] = cur.loc
+				heap.Push(q, pathStep{loc: next, cost: d})
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("there is no way from %s to %s", from, to)
+}
+
+// PathWithin is Path for a unit that can travel budget in one turn. It
+// returns ErrTooFar if even the cheapest path costs more.
+func (m WorldMap) PathWithin(from, to Location, budget int) ([]Location, int, error) {
+	path, cost, err := m.Path(from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cost > budget {
+		return nil, 0, fmt.Errorf("%w: %s to %s costs %d, more than %d", ErrTooFar, from, to, cost, budget)
+	}
+	return path, cost, nil
+}
+
+// CheckPath reports whether path follows the map's edges, and returns what
+// it costs.
+func (m WorldMap) CheckPath(path []Location) (int, error) {
+	if len(path) == 0 {
+		return 0, errors.New("empty path")
+	}
+	if !m.Has(path[0]) {
+		return 0, fmt.Errorf("%s is not a valid location", path[0])
+	}
+	total := 0
+	for i := 1; i < len(path); i++ {
+		cost, ok := m.Neighbors(path[i-1])[path[i]]
+		if !ok {
+			return 0, fmt.Errorf("%s does not border %s", path[i-1], path[i])
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+type pathStep struct {
+	loc  Location
+	cost int
+}
+
+type pathQueue []pathStep
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x any)        { *q = append(*q, x.(pathStep)) }
+
+func (q *pathQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
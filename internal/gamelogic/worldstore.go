@@ -0,0 +1,339 @@
+package gamelogic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	journalFile  = "journal.jsonl"
+)
+
+// FileWorldStore keeps the snapshot and journal as JSON files in a
+// directory: snapshot.json, replaced whole, and journal.jsonl, with one
+// append per line: an event, or an array of the events appended together.
+// Every append is synced to disk. A line cut short by a crash is dropped
+// when the store is opened. Nothing stops two processes from sharing a
+// directory, so give each server its own.
+type FileWorldStore struct {
+	dir string
+
+	mu      sync.Mutex
+	journal journal
+}
+
+// journal is the open journal file. It is only an interface so tests can
+// make writes fail.
+type journal interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+func OpenFileWorldStore(dir string) (*FileWorldStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// O_APPEND keeps writes at the end of the file when a failed append
+	// truncates it, rather than leaving a gap where the partial line was.
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncateTornLine(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("recovering %s: %w", f.Name(), err)
+	}
+	return &FileWorldStore{dir: dir, journal: f}, nil
+}
+
+// truncateTornLine cuts f after its last complete line and leaves the
+// offset at the end.
+func truncateTornLine(f *os.File) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	good := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if good < int64(len(data)) {
+		if err := f.Truncate(good); err != nil {
+			return err
+		}
+	}
+	_, err = f.Seek(good, io.SeekStart)
+	return err
+}
+
+func (s *FileWorldStore) Append(events ...Event) error {
+	var v any = events
+	switch len(events) {
+	case 0:
+		return nil
+	case 1:
+		v = events[0]
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.journal.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = s.journal.Write(append(line, '\n'))
+	if err == nil {
+		err = s.journal.Sync()
+	}
+	if err != nil {
+		// Don't leave part of the line for the next append to follow.
+		s.journal.Truncate(info.Size())
+		return err
+	}
+	return nil
+}
+
+func (s *FileWorldStore) Events(after uint64) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readJournal(filepath.Join(s.dir, journalFile), after)
+}
+
+func readJournal(path string, after uint64) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []Event
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		var appended []Event
+		var err error
+		if data := sc.Bytes(); len(data) > 0 && data[0] == '[' {
+			err = json.Unmarshal(data, &appended)
+		} else {
+			appended = make([]Event, 1)
+			err = json.Unmarshal(data, &appended[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		for _, e := range appended {
+			if e.Seq > after {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, sc.Err()
+}
+
+// SaveSnapshot replaces the snapshot, then rewrites the journal without the
+// events it covers. Each file is replaced by renaming a new one over it, so
+// a crash leaves either the old or the new version.
+func (s *FileWorldStore) SaveSnapshot(snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileAtomic(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, journalFile)
+	events, err := readJournal(path, snap.Seq)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, buf.Bytes()); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.journal.Close()
+	s.journal = f
+	return nil
+}
+
+func (s *FileWorldStore) LoadSnapshot() (Snapshot, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, false, nil
+	}
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return Snapshot{}, false, fmt.Errorf("%s: %w", snapshotFile, err)
+	}
+	return snap, true, nil
+}
+
+func (s *FileWorldStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.journal.Close()
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var (
+	snapshotBucket = []byte("snapshot")
+	journalBucket  = []byte("journal")
+	snapshotKey    = []byte("latest")
+)
+
+// BoltWorldStore keeps the snapshot and journal in a BoltDB file. Unlike
+// FileWorldStore, it locks the file, so a second server can't open it.
+type BoltWorldStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltWorldStore(path string) (*BoltWorldStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(snapshotBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(journalBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltWorldStore{db: db}, nil
+}
+
+func seqKey(seq uint64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], seq)
+	return k[:]
+}
+
+func (s *BoltWorldStore) Append(events ...Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		journal := tx.Bucket(journalBucket)
+		for _, e := range events {
+			v, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err := journal.Put(seqKey(e.Seq), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltWorldStore) Events(after uint64) ([]Event, error) {
+	var events []Event
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(journalBucket).Cursor()
+		for k, v := c.Seek(seqKey(after + 1)); k != nil; k, v = c.Next() {
+			var e Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("event %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (s *BoltWorldStore) SaveSnapshot(snap Snapshot) error {
+	v, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(snapshotBucket).Put(snapshotKey, v); err != nil {
+			return err
+		}
+		// Deleting under a cursor can skip keys, so collect them first.
+		journal := tx.Bucket(journalBucket)
+		var covered [][]byte
+		c := journal.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= snap.Seq; k, _ = c.Next() {
+			covered = append(covered, append([]byte(nil), k...))
+		}
+		for _, k := range covered {
+			if err := journal.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltWorldStore) LoadSnapshot() (Snapshot, bool, error) {
+	var (
+		snap Snapshot
+		ok   bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(snapshotBucket).Get(snapshotKey)
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &snap)
+	})
+	return snap, ok, err
+}
+
+func (s *BoltWorldStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,149 @@
+package gamelogic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorldStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) WorldStore{
+		"memory": func(t *testing.T) WorldStore { return NewMemoryWorldStore() },
+		"file": func(t *testing.T) WorldStore {
+			s, err := OpenFileWorldStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"bolt": func(t *testing.T) WorldStore {
+			s, err := OpenBoltWorldStore(filepath.Join(t.TempDir(), "world.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			if err := s.Append(Event{Seq: 1, Kind: EventJoin, Player: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+			batch := []Event{
+				{Seq: 2, Kind: EventIncome, Player: "alice", Treasury: 12},
+				{Seq: 3, Kind: EventTurn, Turn: 2},
+			}
+			if err := s.Append(batch...); err != nil {
+				t.Fatal(err)
+			}
+			events, err := s.Events(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, batch) {
+				t.Fatalf("Events(1) = %+v, want %+v", events, batch)
+			}
+
+			if err := s.SaveSnapshot(Snapshot{Seq: 2, Turn: 1}); err != nil {
+				t.Fatal(err)
+			}
+			snap, ok, err := s.LoadSnapshot()
+			if err != nil || !ok || snap.Seq != 2 {
+				t.Fatalf("LoadSnapshot = %+v, %v, %v", snap, ok, err)
+			}
+			events, err = s.Events(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].Seq != 3 {
+				t.Fatalf("after the snapshot, Events(0) = %+v", events)
+			}
+		})
+	}
+}
+
+func TestFileWorldStoreDropsTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileWorldStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(Event{Seq: 1, Kind: EventJoin, Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A crash halfway through writing a turn's events.
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`[{"Seq":2,"Kind":"income","Player":"alice"},{"Seq":3,"Ki`)
+	f.Close()
+
+	s, err = OpenFileWorldStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, err := s.Events(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Seq != 1 {
+		t.Fatalf("Events(0) = %+v, want only the join", events)
+	}
+	if err := s.Append(Event{Seq: 2, Kind: EventTurn, Turn: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := s.Events(1); err != nil || len(events) != 1 {
+		t.Fatalf("Events(1) = %+v, %v", events, err)
+	}
+}
+
+// shortWriteJournal writes half of what it's given, then fails, like a full
+// disk.
+type shortWriteJournal struct{ journal }
+
+func (j shortWriteJournal) Write(p []byte) (int, error) {
+	n, _ := j.journal.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func TestFileWorldStoreRecoversFromFailedAppend(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileWorldStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(Event{Seq: 1, Kind: EventJoin, Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	f := s.journal
+	s.journal = shortWriteJournal{f}
+	if err := s.Append(Event{Seq: 2, Kind: EventTurn, Turn: 2}); err == nil {
+		t.Fatal("Append succeeded on a failed write")
+	}
+	s.journal = f
+	if err := s.Append(Event{Seq: 2, Kind: EventTurn, Turn: 2}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenFileWorldStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, err := s.Events(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Seq != 1 || events[1].Seq != 2 {
+		t.Fatalf("Events(0) = %+v, want the join and the turn", events)
+	}
+}
@@ -0,0 +1,881 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultMinBackoff     = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultConfirmTimeout = 5 * time.Second
+
+	// directReplyTo is RabbitMQ's pseudo-queue for replies that skip
+	// declaring a queue per request.
+	directReplyTo = "amq.rabbitmq.reply-to"
+)
+
+// AMQPBroker is a managed RabbitMQ connection. It remembers the topology it
+// has declared and the consumers it has started, and when the connection
+// drops it redials with backoff, declares everything again and reattaches
+// the consumers to the same delivery channels.
+type AMQPBroker struct {
+	url        string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	onReconnecting func(attempt int, err error)
+	onReconnected  func()
+	confirms       bool
+
+	maxChannels int
+	pool        *channelPool
+
+	// Publishing waits while the server has paused a publishing channel
+	// with channel.flow or blocked the connection for lack of resources.
+	flowMu      sync.Mutex
+	flowStopped int
+	blocked     bool
+	blockedConn *amqp.Connection
+	resumed     chan struct{}
+
+	getMu   sync.Mutex
+	getConn *amqp.Connection
+	getCh   *amqp.Channel
+
+	mu        sync.Mutex
+	conn      *amqp.Connection
+	closed    bool
+	done      chan struct{}
+	exchanges []exchangeDecl
+	queues    []queueDecl
+	bindings  []bindingDecl
+	consumers []*amqpConsumer
+}
+
+type exchangeDecl struct {
+	name    string
+	kind    string
+	durable bool
+}
+
+// queueDecl is a queue as it was declared. A server-named queue keeps its
+// empty name, and current tracks the name the server last gave it.
+type queueDecl struct {
+	name      string
+	current   string
+	queueType SimpleQueueType
+	args      Table
+}
+
+type bindingDecl struct {
+	queue    string
+	key      string
+	exchange string
+}
+
+type amqpConsumer struct {
+	queue     string
+	tag       string
+	prefetch  int
+	out       chan RawDelivery
+	wg        sync.WaitGroup
+	ch        *amqp.Channel
+	cancelled bool
+	closeOnce sync.Once
+}
+
+// finish closes out once every forwarding goroutine is done with it.
+func (c *amqpConsumer) finish() {
+	c.wg.Wait()
+	c.closeOnce.Do(func() { close(c.out) })
+}
+
+type DialOption func(*AMQPBroker)
+
+func WithBackoff(min, max time.Duration) DialOption {
+	return func(b *AMQPBroker) {
+		b.minBackoff = min
+		b.maxBackoff = max
+	}
+}
+
+// OnReconnecting is called before every redial attempt with the error that
+// caused the connection (or the previous attempt) to fail.
+func OnReconnecting(f func(attempt int, err error)) DialOption {
+	return func(b *AMQPBroker) {
+		b.onReconnecting = f
+	}
+}
+
+// OnReconnected is called once the topology and consumers are restored.
+func OnReconnected(f func()) DialOption {
+	return func(b *AMQPBroker) {
+		b.onReconnected = f
+	}
+}
+
+// WithConfirms puts the publishing channels in confirm mode, so Publish only
+// returns once the broker has taken responsibility for the message. It is
+// also what lets mandatory publishes report ErrUnroutable.
+func WithConfirms() DialOption {
+	return func(b *AMQPBroker) {
+		b.confirms = true
+	}
+}
+
+// WithMaxChannels caps how many channels the broker opens for publishing
+// at once. Publishes beyond that wait for a channel to come free.
+func WithMaxChannels(n int) DialOption {
+	return func(b *AMQPBroker) {
+		b.maxChannels = max(n, 1)
+	}
+}
+
+func Dial(url string, opts ...DialOption) (*AMQPBroker, error) {
+	b := &AMQPBroker{
+		url:         url,
+		minBackoff:  defaultMinBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		maxChannels: defaultMaxChannels,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.pool = newChannelPool(b, b.maxChannels)
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	go b.watch(conn)
+	return b, nil
+}
+
+func (b *AMQPBroker) DeclareExchange(name, kind string, durable bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.declareExchange(exchangeDecl{name: name, kind: kind, durable: durable}); err != nil {
+		return err
+	}
+	for _, ex := range b.exchanges {
+		if ex.name == name {
+			return nil
+		}
+	}
+	b.exchanges = append(b.exchanges, exchangeDecl{name: name, kind: kind, durable: durable})
+	return nil
+}
+
+func (b *AMQPBroker) DeclareQueue(name string, queueType SimpleQueueType, args Table) (Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	decl := queueDecl{name: name, queueType: queueType, args: args}
+	queue, err := b.declareQueue(decl)
+	if err != nil {
+		return queue, err
+	}
+	decl.current = queue.Name
+	for _, q := range b.queues {
+		if name != "" && q.name == name {
+			return queue, nil
+		}
+	}
+	b.queues = append(b.queues, decl)
+	return queue, nil
+}
+
+func (b *AMQPBroker) BindQueue(queueName, key, exchange string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	decl := bindingDecl{queue: queueName, key: key, exchange: exchange}
+	if err := b.bindQueue(decl); err != nil {
+		return err
+	}
+	for _, bind := range b.bindings {
+		if bind == decl {
+			return nil
+		}
+	}
+	b.bindings = append(b.bindings, decl)
+	return nil
+}
+
+func (b *AMQPBroker) Publish(ctx context.Context, exchange, key string, msg Message) error {
+	if err := b.waitFlow(ctx); err != nil {
+		return err
+	}
+	pc, err := b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.pool.put(pc)
+	return b.publishOn(ctx, pc, exchange, key, msg)
+}
+
+func (b *AMQPBroker) publishOn(ctx context.Context, pc *pooledChannel, exchange, key string, msg Message) error {
+	if !b.confirms {
+		return pc.ch.PublishWithContext(ctx, exchange, key, msg.Mandatory, false, toAMQPPublishing(msg))
+	}
+	err := publishConfirmed(ctx, pc.ch, pc.returns, exchange, key, msg)
+	if ctx.Err() != nil {
+		// A late return for this message would be blamed on the next
+		// publish, so the pool must not hand this channel out again.
+		pc.ch.Close()
+	}
+	return err
+}
+
+// publishConfirmed publishes on a channel in confirm mode and waits for the
+// broker to settle the message. Only one publish may be in flight on ch.
+func publishConfirmed(ctx context.Context, ch *amqp.Channel, returns <-chan amqp.Return, exchange, key string, msg Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultConfirmTimeout)
+		defer cancel()
+	}
+	conf, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, msg.Mandatory, false, toAMQPPublishing(msg))
+	if err != nil {
+		return err
+	}
+	acked, err := conf.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		if ch.IsClosed() {
+			return amqp.ErrClosed
+		}
+		return ErrNacked
+	}
+	// The broker sends basic.return before basic.ack, and only one publish is
+	// in flight on this channel, so any return is already buffered and is ours.
+	select {
+	case ret := <-returns:
+		return &UnroutableError{
+			Exchange:   ret.Exchange,
+			RoutingKey: ret.RoutingKey,
+			ReplyCode:  int(ret.ReplyCode),
+			ReplyText:  ret.ReplyText,
+		}
+	default:
+		return nil
+	}
+}
+
+// PublishForReply publishes msg with RabbitMQ's direct reply-to, which
+// needs the reply consumer and the publish on one channel of their own.
+// The returned channel yields the replies until done is called.
+func (b *AMQPBroker) PublishForReply(ctx context.Context, exchange, key string, msg Message) (replies <-chan RawDelivery, done func(), err error) {
+	ch, release, err := b.pool.dedicated(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	deliveries, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	msg.ReplyTo = directReplyTo
+	if b.confirms {
+		if err := ch.Confirm(false); err != nil {
+			release()
+			return nil, nil, err
+		}
+		returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+		err = publishConfirmed(ctx, ch, returns, exchange, key, msg)
+	} else {
+		err = ch.PublishWithContext(ctx, exchange, key, msg.Mandatory, false, toAMQPPublishing(msg))
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	out := make(chan RawDelivery)
+	stop := make(chan struct{})
+	go func() {
+		defer close(out)
+		for d := range deliveries {
+			reply := fromAMQPDelivery(d, nil)
+			reply.acker = autoAcker{}
+			select {
+			case out <- reply:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			close(stop)
+			release()
+		})
+	}, nil
+}
+
+// connection returns the current connection, which may be one that has
+// just dropped and is being replaced.
+func (b *AMQPBroker) connection() (*amqp.Connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	return b.conn, nil
+}
+
+// PublishBatch publishes every message on one channel before waiting for
+// any confirm, so the whole batch costs one round trip. A batch can't tell
+// which message a return belongs to, so mandatory messages are published
+// one at a time.
+func (b *AMQPBroker) PublishBatch(ctx context.Context, batch []Publishing) error {
+	if err := b.waitFlow(ctx); err != nil {
+		return err
+	}
+	pc, err := b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.pool.put(pc)
+	return b.publishBatchOn(ctx, pc, batch)
+}
+
+func (b *AMQPBroker) publishBatchOn(ctx context.Context, pc *pooledChannel, batch []Publishing) error {
+	var rest []Publishing
+	for _, p := range batch {
+		if p.Message.Mandatory {
+			if err := b.publishOn(ctx, pc, p.Exchange, p.Key, p.Message); err != nil {
+				return err
+			}
+			continue
+		}
+		rest = append(rest, p)
+	}
+	ch := pc.ch
+	if !b.confirms {
+		for _, p := range rest {
+			if err := ch.PublishWithContext(ctx, p.Exchange, p.Key, false, false, toAMQPPublishing(p.Message)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultConfirmTimeout)
+		defer cancel()
+	}
+	confirms := make([]*amqp.DeferredConfirmation, 0, len(rest))
+	for _, p := range rest {
+		conf, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.Exchange, p.Key, false, false, toAMQPPublishing(p.Message))
+		if err != nil {
+			return err
+		}
+		confirms = append(confirms, conf)
+	}
+	nacked := 0
+	for _, conf := range confirms {
+		acked, err := conf.WaitContext(ctx)
+		if err != nil {
+			ch.Close()
+			return err
+		}
+		if !acked {
+			nacked++
+		}
+	}
+	if nacked > 0 {
+		if ch.IsClosed() {
+			return amqp.ErrClosed
+		}
+		return fmt.Errorf("%w: %d of %d messages", ErrNacked, nacked, len(rest))
+	}
+	return nil
+}
+
+// watchFlow tracks channel.flow on a publishing channel, and
+// connection.blocked on its connection the first time it is seen.
+func (b *AMQPBroker) watchFlow(conn *amqp.Connection, ch *amqp.Channel) {
+	go func() {
+		stopped := false
+		for active := range ch.NotifyFlow(make(chan bool, 1)) {
+			switch {
+			case !active && !stopped:
+				b.updateFlow(func() { b.flowStopped++ })
+			case active && stopped:
+				b.updateFlow(func() { b.flowStopped-- })
+			}
+			stopped = !active
+		}
+		// The channel is gone; it no longer holds anything up.
+		if stopped {
+			b.updateFlow(func() { b.flowStopped-- })
+		}
+	}()
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	if b.blockedConn == conn {
+		return
+	}
+	b.blockedConn = conn
+	blockings := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+	go func() {
+		for blocking := range blockings {
+			b.updateFlow(func() { b.blocked = blocking.Active })
+		}
+		b.updateFlow(func() { b.blocked = false })
+	}()
+}
+
+func (b *AMQPBroker) updateFlow(update func()) {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	wasPaused := b.flowStopped > 0 || b.blocked
+	update()
+	paused := b.flowStopped > 0 || b.blocked
+	switch {
+	case paused && !wasPaused:
+		b.resumed = make(chan struct{})
+	case !paused && wasPaused:
+		close(b.resumed)
+	}
+}
+
+// waitFlow blocks while the server doesn't want us publishing.
+func (b *AMQPBroker) waitFlow(ctx context.Context) error {
+	b.flowMu.Lock()
+	if b.flowStopped == 0 && !b.blocked {
+		b.flowMu.Unlock()
+		return nil
+	}
+	resumed := b.resumed
+	b.flowMu.Unlock()
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return ErrClosed
+	}
+}
+
+func (b *AMQPBroker) Consume(queueName, consumerTag string, prefetch int) (<-chan RawDelivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	c := &amqpConsumer{
+		queue:    queueName,
+		tag:      consumerTag,
+		prefetch: prefetch,
+		out:      make(chan RawDelivery),
+	}
+	if err := b.attach(c); err != nil {
+		return nil, err
+	}
+	b.consumers = append(b.consumers, c)
+	return c.out, nil
+}
+
+// Get fetches a single message without consuming. It stays unacked on a
+// channel shared by all Get calls until it is settled.
+func (b *AMQPBroker) Get(queueName string) (RawDelivery, bool, error) {
+	b.getMu.Lock()
+	defer b.getMu.Unlock()
+	b.mu.Lock()
+	conn, closed := b.conn, b.closed
+	b.mu.Unlock()
+	if closed {
+		return RawDelivery{}, false, ErrClosed
+	}
+	if b.getCh == nil || b.getCh.IsClosed() || b.getConn != conn {
+		ch, err := conn.Channel()
+		if err != nil {
+			return RawDelivery{}, false, err
+		}
+		b.getConn, b.getCh = conn, ch
+	}
+	d, ok, err := b.getCh.Get(queueName, false)
+	if err != nil || !ok {
+		return RawDelivery{}, false, err
+	}
+	var unsettled sync.WaitGroup
+	unsettled.Add(1)
+	return fromAMQPDelivery(d, &unsettled), true, nil
+}
+
+// Cancel stops a consumer. Once the broker is closed every consumer has
+// already stopped, so there is nothing left to cancel.
+func (b *AMQPBroker) Cancel(consumerTag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	for i, c := range b.consumers {
+		if c.tag != consumerTag {
+			continue
+		}
+		b.consumers = append(b.consumers[:i], b.consumers[i+1:]...)
+		c.cancelled = true
+		go c.finish()
+		if c.ch.IsClosed() {
+			return nil
+		}
+		return c.ch.Cancel(consumerTag, false)
+	}
+	return fmt.Errorf("pubsub: no consumer %q", consumerTag)
+}
+
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	conn := b.conn
+	consumers := b.consumers
+	b.consumers = nil
+	for _, c := range consumers {
+		c.cancelled = true
+	}
+	b.mu.Unlock()
+
+	var err error
+	if !conn.IsClosed() {
+		err = conn.Close()
+	}
+	for _, c := range consumers {
+		c.finish()
+	}
+	return err
+}
+
+func (b *AMQPBroker) declareExchange(ex exchangeDecl) error {
+	return b.withChannel(func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(ex.name, ex.kind, ex.durable, false, false, false, nil)
+	})
+}
+
+func (b *AMQPBroker) declareQueue(decl queueDecl) (Queue, error) {
+	var queue Queue
+	err := b.withChannel(func(ch *amqp.Channel) error {
+		q, err := ch.QueueDeclare(
+			decl.name,
+			decl.queueType == Durable,
+			decl.queueType == Transient,
+			decl.queueType == Transient,
+			false,
+			toAMQPTable(decl.args),
+		)
+		if err != nil {
+			return err
+		}
+		queue = Queue{Name: q.Name, Messages: q.Messages, Consumers: q.Consumers}
+		return nil
+	})
+	return queue, err
+}
+
+func (b *AMQPBroker) bindQueue(decl bindingDecl) error {
+	return b.withChannel(func(ch *amqp.Channel) error {
+		return ch.QueueBind(decl.queue, decl.key, decl.exchange, false, nil)
+	})
+}
+
+// withChannel runs f on a short-lived channel, since a failed declare closes
+// the channel it was issued on. Callers must hold b.mu.
+func (b *AMQPBroker) withChannel(f func(*amqp.Channel) error) error {
+	if b.closed {
+		return ErrClosed
+	}
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return f(ch)
+}
+
+// attach starts consuming for c on the current connection. The forwarding
+// goroutine ends when the channel closes or the consumer is cancelled, and
+// keeps the channel open until every delivery it handed out is settled.
+// Unless it was cancelled the consumer itself lives on and is attached
+// again, either by reattach if only the channel died or by the next
+// reconnect. Callers must hold b.mu.
+func (b *AMQPBroker) attach(c *amqpConsumer) error {
+	conn := b.conn
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		ch.Close()
+		return err
+	}
+	deliveryChan, err := ch.Consume(c.queue, c.tag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	c.ch = ch
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		var unsettled sync.WaitGroup
+		for d := range deliveryChan {
+			unsettled.Add(1)
+			select {
+			case c.out <- fromAMQPDelivery(d, &unsettled):
+			case <-b.done:
+				d.Nack(false, true)
+				unsettled.Done()
+			}
+		}
+		unsettled.Wait()
+		ch.Close()
+		go b.reattach(c, conn)
+	}()
+	return nil
+}
+
+// reattach starts c again after its channel closed on a connection that is
+// still up. It retries with the reconnect backoff until it works, or until
+// the consumer is cancelled, the broker closed or the connection lost, when
+// restore takes over.
+func (b *AMQPBroker) reattach(c *amqpConsumer, conn *amqp.Connection) {
+	delay := b.minBackoff
+	for {
+		b.mu.Lock()
+		if b.closed || c.cancelled || b.conn != conn || conn.IsClosed() {
+			b.mu.Unlock()
+			return
+		}
+		err := b.attach(c)
+		b.mu.Unlock()
+		if err == nil {
+			return
+		}
+		fmt.Printf("error re-attaching consumer %s, retrying in %v: %v\n", c.tag, delay, err)
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, b.maxBackoff)
+	}
+}
+
+func (b *AMQPBroker) watch(conn *amqp.Connection) {
+	var err error = amqp.ErrClosed
+	if closeErr := <-conn.NotifyClose(make(chan *amqp.Error, 1)); closeErr != nil {
+		err = closeErr
+	}
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	delay := b.minBackoff
+	for attempt := 1; ; attempt++ {
+		if b.onReconnecting != nil {
+			b.onReconnecting(attempt, err)
+		}
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+		reconnectAttempts.Inc()
+		delay = min(delay*2, b.maxBackoff)
+
+		var next *amqp.Connection
+		next, err = amqp.Dial(b.url)
+		if err != nil {
+			continue
+		}
+		if err = b.restore(next); err != nil {
+			next.Close()
+			continue
+		}
+		reconnects.Inc()
+		if b.onReconnected != nil {
+			b.onReconnected()
+		}
+		go b.watch(next)
+		return
+	}
+}
+
+// restore swaps in a fresh connection and replays every exchange, queue,
+// binding and consumer registered on the old one. Server-named queues are
+// declared afresh and their bindings and consumers follow the new names.
+func (b *AMQPBroker) restore(conn *amqp.Connection) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	b.conn = conn
+	for _, ex := range b.exchanges {
+		if err := b.declareExchange(ex); err != nil {
+			return err
+		}
+	}
+	for i, q := range b.queues {
+		queue, err := b.declareQueue(q)
+		if err != nil {
+			return err
+		}
+		if queue.Name != q.current {
+			b.renameQueue(q.current, queue.Name)
+			b.queues[i].current = queue.Name
+		}
+	}
+	for _, bind := range b.bindings {
+		if err := b.bindQueue(bind); err != nil {
+			return err
+		}
+	}
+	for _, c := range b.consumers {
+		if err := b.attach(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameQueue points the bindings and consumers of a server-named queue at
+// the name the server gave it on the new connection. Callers must hold b.mu.
+func (b *AMQPBroker) renameQueue(from, to string) {
+	for i := range b.bindings {
+		if b.bindings[i].queue == from {
+			b.bindings[i].queue = to
+		}
+	}
+	for _, c := range b.consumers {
+		if c.queue == from {
+			c.queue = to
+		}
+	}
+}
+
+type amqpAcker struct {
+	d         amqp.Delivery
+	once      sync.Once
+	unsettled *sync.WaitGroup
+}
+
+func (a *amqpAcker) Ack() error {
+	defer a.once.Do(a.unsettled.Done)
+	return a.d.Ack(false)
+}
+
+func (a *amqpAcker) Nack(requeue bool) error {
+	defer a.once.Do(a.unsettled.Done)
+	return a.d.Nack(false, requeue)
+}
+
+// autoAcker settles deliveries the broker has already considered acked,
+// such as direct replies.
+type autoAcker struct{}
+
+func (autoAcker) Ack() error              { return nil }
+func (autoAcker) Nack(requeue bool) error { return nil }
+
+func toAMQPPublishing(msg Message) amqp.Publishing {
+	return amqp.Publishing{
+		ContentType:   msg.ContentType,
+		Headers:       toAMQPTable(msg.Headers),
+		Body:          msg.Body,
+		MessageId:     msg.MessageID,
+		CorrelationId: msg.CorrelationID,
+		ReplyTo:       msg.ReplyTo,
+		AppId:         msg.AppID,
+		Timestamp:     msg.Timestamp,
+	}
+}
+
+func fromAMQPDelivery(d amqp.Delivery, unsettled *sync.WaitGroup) RawDelivery {
+	return RawDelivery{
+		Message: Message{
+			ContentType:   d.ContentType,
+			Headers:       fromAMQPTable(d.Headers),
+			Body:          d.Body,
+			MessageID:     d.MessageId,
+			CorrelationID: d.CorrelationId,
+			ReplyTo:       d.ReplyTo,
+			AppID:         d.AppId,
+			Timestamp:     d.Timestamp,
+		},
+		Exchange:    d.Exchange,
+		RoutingKey:  d.RoutingKey,
+		Redelivered: d.Redelivered,
+		acker:       &amqpAcker{d: d, unsettled: unsettled},
+	}
+}
+
+// toAMQPTable and fromAMQPTable convert nested tables too: amqp091 only
+// accepts its own Table type inside headers, and x-death arrives as a list
+// of them.
+func toAMQPTable(t Table) amqp.Table {
+	if t == nil {
+		return nil
+	}
+	converted := make(amqp.Table, len(t))
+	for k, v := range t {
+		converted[k] = toAMQPValue(v)
+	}
+	return converted
+}
+
+func toAMQPValue(v any) any {
+	switch v := v.(type) {
+	case Table:
+		return toAMQPTable(v)
+	case []any:
+		converted := make([]any, len(v))
+		for i, item := range v {
+			converted[i] = toAMQPValue(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
+
+func fromAMQPTable(t amqp.Table) Table {
+	if t == nil {
+		return nil
+	}
+	converted := make(Table, len(t))
+	for k, v := range t {
+		converted[k] = fromAMQPValue(v)
+	}
+	return converted
+}
+
+func fromAMQPValue(v any) any {
+	switch v := v.(type) {
+	case amqp.Table:
+		return fromAMQPTable(v)
+	case []any:
+		converted := make([]any, len(v))
+		for i, item := range v {
+			converted[i] = fromAMQPValue(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// dialTest connects to the RabbitMQ server at $PERIL_AMQP_URL, skipping the
+// test when it isn't set.
+func dialTest(t *testing.T, opts ...DialOption) *AMQPBroker {
+	t.Helper()
+	url := os.Getenv("PERIL_AMQP_URL")
+	if url == "" {
+		t.Skip("PERIL_AMQP_URL is not set")
+	}
+	b, err := Dial(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// dropConnection closes the broker's connection from under it, as a
+// network failure would.
+func dropConnection(t *testing.T, b *AMQPBroker) {
+	t.Helper()
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAMQPReconnect(t *testing.T) {
+	reconnected := make(chan struct{}, 1)
+	b := dialTest(t,
+		WithBackoff(10*time.Millisecond, 100*time.Millisecond),
+		OnReconnected(func() { reconnected <- struct{}{} }),
+	)
+	declare(t, b, "peril_test_reconnect", ExchangeTopic, "peril_test_reconnect", "#", nil)
+	deliveries, err := b.Consume("peril_test_reconnect", "peril_test_reconnect", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dropConnection(t, b)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't reconnect")
+	}
+	// The transient queue went with the old connection, so it only gets
+	// this message if it was declared, bound and consumed again.
+	if err := b.Publish(context.Background(), "peril_test_reconnect", "k", Message{Body: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, deliveries); string(d.Body) != "hello" {
+		t.Fatalf("got %q", d.Body)
+	}
+}
+
+func TestAMQPReconnectServerNamedQueue(t *testing.T) {
+	reconnected := make(chan struct{}, 1)
+	b := dialTest(t,
+		WithBackoff(10*time.Millisecond, 100*time.Millisecond),
+		OnReconnected(func() { reconnected <- struct{}{} }),
+	)
+	if err := b.DeclareExchange("peril_test_reconnect", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	queue, err := b.DeclareQueue("", Transient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindQueue(queue.Name, "#", "peril_test_reconnect"); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := b.Consume(queue.Name, "peril_test_server_named", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server won't let a client declare an amq.gen- name itself, so the
+	// queue has to be declared unnamed again to come back at all.
+	dropConnection(t, b)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't reconnect")
+	}
+	if err := b.Publish(context.Background(), "peril_test_reconnect", "k", Message{Body: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, deliveries); string(d.Body) != "hello" {
+		t.Fatalf("got %q", d.Body)
+	}
+}
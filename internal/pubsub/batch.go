@@ -0,0 +1,259 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrBackpressure = errors.New("pubsub: publisher buffer is full")
+
+type BackpressurePolicy int
+
+const (
+	// Block makes Publish wait for room in the buffer.
+	Block BackpressurePolicy = iota
+	// Reject makes Publish fail with ErrBackpressure when the buffer is full.
+	Reject
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = 50 * time.Millisecond
+	defaultBatchBuffer   = 1000
+	defaultConfirmWindow = 100
+)
+
+// BatchPublisher buffers messages and publishes them in batches, once a
+// batch is full or the flush interval has passed since its first message.
+// Brokers that implement BatchBroker publish each batch in windows of
+// unconfirmed messages; others get one Publish per message. When the broker
+// falls behind, including while RabbitMQ has paused publishing with flow
+// control, the buffer fills up and the BackpressurePolicy decides what
+// Publish does.
+//
+// Publish returns once a message is buffered, so publish errors are only
+// reported by the next Flush or Close.
+type BatchPublisher struct {
+	broker        Broker
+	batchSize     int
+	flushInterval time.Duration
+	window        int
+	policy        BackpressurePolicy
+
+	buf     chan Publishing
+	flushes chan chan error
+	quit    chan struct{}
+	stopped chan struct{}
+
+	// closeMu is held for reading while Publish buffers a message and for
+	// writing while Close sets closed, so nothing is buffered once Close has
+	// started draining.
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu  sync.Mutex
+	err error
+}
+
+type BatchOption func(*BatchPublisher)
+
+func WithBatchSize(n int) BatchOption {
+	return func(p *BatchPublisher) {
+		p.batchSize = max(n, 1)
+	}
+}
+
+func WithFlushInterval(d time.Duration) BatchOption {
+	return func(p *BatchPublisher) {
+		p.flushInterval = d
+	}
+}
+
+// WithBufferSize sets how many messages may wait to be published before
+// backpressure kicks in.
+func WithBufferSize(n int) BatchOption {
+	return func(p *BatchPublisher) {
+		p.buf = make(chan Publishing, max(n, 1))
+	}
+}
+
+// WithConfirmWindow caps how many messages are published before waiting for
+// the broker to confirm them.
+func WithConfirmWindow(n int) BatchOption {
+	return func(p *BatchPublisher) {
+		p.window = max(n, 1)
+	}
+}
+
+func WithBackpressure(policy BackpressurePolicy) BatchOption {
+	return func(p *BatchPublisher) {
+		p.policy = policy
+	}
+}
+
+func NewBatchPublisher(b Broker, opts ...BatchOption) *BatchPublisher {
+	p := &BatchPublisher{
+		broker:        b,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		window:        defaultConfirmWindow,
+		buf:           make(chan Publishing, defaultBatchBuffer),
+		flushes:       make(chan chan error),
+		quit:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	go p.run()
+	return p
+}
+
+// Publish buffers msg. ctx only bounds the wait for room in the buffer.
+func (p *BatchPublisher) Publish(ctx context.Context, exchange, key string, msg Message) error {
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	pub := Publishing{Exchange: exchange, Key: key, Message: msg}
+	if p.policy == Reject {
+		select {
+		case p.buf <- pub:
+			return nil
+		default:
+			return ErrBackpressure
+		}
+	}
+	select {
+	case p.buf <- pub:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush publishes everything buffered so far and returns the first error
+// since the previous Flush.
+func (p *BatchPublisher) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case p.flushes <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.stopped:
+		return ErrClosed
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close publishes what is left in the buffer, including messages that
+// Publish calls are still waiting to buffer, and stops the publisher. It
+// does not close the broker.
+func (p *BatchPublisher) Close() error {
+	p.closeMu.Lock()
+	if p.closed {
+		p.closeMu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.closeMu.Unlock()
+	close(p.quit)
+	<-p.stopped
+	return p.takeErr()
+}
+
+func (p *BatchPublisher) run() {
+	defer close(p.stopped)
+	var (
+		batch []Publishing
+		timer = time.NewTimer(p.flushInterval)
+		timed bool
+	)
+	timer.Stop()
+	flush := func() {
+		if timed && !timer.Stop() {
+			<-timer.C
+		}
+		timed = false
+		p.publish(batch)
+		batch = batch[:0]
+	}
+	drain := func() {
+		for {
+			select {
+			case pub := <-p.buf:
+				batch = append(batch, pub)
+			default:
+				flush()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case pub := <-p.buf:
+			batch = append(batch, pub)
+			if len(batch) >= p.batchSize {
+				flush()
+			} else if !timed {
+				timer.Reset(p.flushInterval)
+				timed = true
+			}
+		case <-timer.C:
+			timed = false
+			flush()
+		case reply := <-p.flushes:
+			drain()
+			reply <- p.takeErr()
+		case <-p.quit:
+			drain()
+			return
+		}
+	}
+}
+
+func (p *BatchPublisher) publish(batch []Publishing) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx := context.Background()
+	bb, ok := p.broker.(BatchBroker)
+	if !ok {
+		for _, pub := range batch {
+			if err := p.broker.Publish(ctx, pub.Exchange, pub.Key, pub.Message); err != nil {
+				p.setErr(err)
+			}
+		}
+		return
+	}
+	for start := 0; start < len(batch); start += p.window {
+		end := min(start+p.window, len(batch))
+		if err := bb.PublishBatch(ctx, batch[start:end]); err != nil {
+			p.setErr(err)
+		}
+	}
+}
+
+func (p *BatchPublisher) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *BatchPublisher) takeErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.err
+	p.err = nil
+	return err
+}
@@ -0,0 +1,253 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedBroker holds every Publish until the gate is opened.
+type gatedBroker struct {
+	Broker
+	gate chan struct{}
+}
+
+func (b gatedBroker) Publish(ctx context.Context, exchange, key string, msg Message) error {
+	<-b.gate
+	return b.Broker.Publish(ctx, exchange, key, msg)
+}
+
+// recordingBatchBroker remembers the size of every batch it is given.
+type recordingBatchBroker struct {
+	Broker
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (b *recordingBatchBroker) PublishBatch(ctx context.Context, batch []Publishing) error {
+	b.mu.Lock()
+	b.batches = append(b.batches, len(batch))
+	b.mu.Unlock()
+	for _, pub := range batch {
+		if err := b.Broker.Publish(ctx, pub.Exchange, pub.Key, pub.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countMessages(t *testing.T, b Broker, queue string) int {
+	t.Helper()
+	n := 0
+	for {
+		d, ok, err := b.Get(queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return n
+		}
+		d.Ack()
+		n++
+	}
+}
+
+func TestBatchPublisherFlushesFullBatch(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	p := NewBatchPublisher(b, WithBatchSize(3), WithFlushInterval(time.Hour))
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(context.Background(), "ex", "k", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := countMessages(t, b, "q"); n != 0 {
+		t.Fatalf("published %d messages before the batch was full", n)
+	}
+	if err := p.Publish(context.Background(), "ex", "k", Message{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMessage(t, b, "q")
+}
+
+func TestBatchPublisherFlushesAfterInterval(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	p := NewBatchPublisher(b, WithBatchSize(100), WithFlushInterval(10*time.Millisecond))
+	defer p.Close()
+
+	if err := p.Publish(context.Background(), "ex", "k", Message{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMessage(t, b, "q")
+}
+
+func TestBatchPublisherFlush(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	p := NewBatchPublisher(b, WithFlushInterval(time.Hour))
+	defer p.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := p.Publish(ctx, "ex", "k", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, b, "q"); n != 5 {
+		t.Fatalf("Flush published %d messages, want 5", n)
+	}
+
+	// Publish errors are reported by the next Flush, once.
+	if err := p.Publish(ctx, "missing", "k", Message{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(ctx); err == nil {
+		t.Fatal("Flush didn't report the failed publish")
+	}
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("second Flush = %v, want nil", err)
+	}
+}
+
+func TestBatchPublisherConfirmWindow(t *testing.T) {
+	b := &recordingBatchBroker{Broker: NewMemoryServer().Connect()}
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	p := NewBatchPublisher(b, WithBatchSize(10), WithConfirmWindow(4), WithFlushInterval(time.Hour))
+	defer p.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := p.Publish(context.Background(), "ex", "k", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if got, want := b.batches, []int{4, 4, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestBatchPublisherBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BackpressurePolicy
+		want   error
+	}{
+		{"reject", Reject, ErrBackpressure},
+		{"block", Block, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := gatedBroker{Broker: NewMemoryServer().Connect(), gate: make(chan struct{})}
+			defer b.Close()
+			declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+			p := NewBatchPublisher(b, WithBatchSize(1), WithBufferSize(1), WithBackpressure(tt.policy))
+
+			// The first message is taken and held at the gate, the second
+			// fills the buffer.
+			for i := 0; i < 2; i++ {
+				if err := p.Publish(context.Background(), "ex", "k", Message{}); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := p.Publish(ctx, "ex", "k", Message{}); !errors.Is(err, tt.want) {
+				t.Fatalf("Publish with a full buffer = %v, want %v", err, tt.want)
+			}
+
+			close(b.gate)
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if n := countMessages(t, b, "q"); n != 2 {
+				t.Fatalf("published %d messages, want the 2 that were buffered", n)
+			}
+		})
+	}
+}
+
+func TestBatchPublisherClose(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	p := NewBatchPublisher(b, WithFlushInterval(time.Hour))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Publish(ctx, "ex", "k", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, b, "q"); n != 3 {
+		t.Fatalf("Close published %d messages, want 3", n)
+	}
+	if err := p.Publish(ctx, "ex", "k", Message{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish after Close = %v, want ErrClosed", err)
+	}
+	if err := p.Flush(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Flush after Close = %v, want ErrClosed", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+}
+
+func TestBatchPublisherCloseWaitsForPublish(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	gate := make(chan struct{})
+	p := NewBatchPublisher(gatedBroker{Broker: b, gate: gate}, WithBatchSize(1), WithBufferSize(1))
+	ctx := context.Background()
+
+	// The first message is stuck publishing and the second fills the
+	// buffer, so the third waits for room while Close is called.
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(ctx, "ex", "k", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published := make(chan error, 1)
+	go func() { published <- p.Publish(ctx, "ex", "k", Message{}) }()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	close(gate)
+
+	if err := <-published; err != nil {
+		t.Fatalf("Publish waiting for room during Close = %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if n := countMessages(t, b, "q"); n != 3 {
+		t.Fatalf("published %d messages, want 3", n)
+	}
+	if err := p.Publish(ctx, "ex", "k", Message{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ExchangeDirect = "direct"
+	ExchangeTopic  = "topic"
+	ExchangeFanout = "fanout"
+)
+
+var (
+	ErrClosed     = errors.New("pubsub: broker is closed")
+	ErrNacked     = errors.New("pubsub: broker nacked the message")
+	ErrUnroutable = errors.New("pubsub: message could not be routed to any queue")
+)
+
+// UnroutableError is returned for a mandatory publish the broker sent back
+// because no queue was bound to its routing key. It matches ErrUnroutable.
+type UnroutableError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  int
+	ReplyText  string
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("pubsub: %s/%s was returned: %d %s", e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+func (e *UnroutableError) Unwrap() error {
+	return ErrUnroutable
+}
+
+type Table map[string]any
+
+type Queue struct {
+	Name      string
+	Messages  int
+	Consumers int
+}
+
+type Message struct {
+	ContentType   string
+	Headers       Table
+	Body          []byte
+	MessageID     string
+	CorrelationID string
+	ReplyTo       string
+	AppID         string
+	Timestamp     time.Time
+	// Mandatory asks the broker to return the message instead of dropping it
+	// when it matches no binding.
+	Mandatory bool
+}
+
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+}
+
+type RawDelivery struct {
+	Message
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+
+	acker Acknowledger
+}
+
+func (d RawDelivery) Ack() error {
+	return d.acker.Ack()
+}
+
+func (d RawDelivery) Nack(requeue bool) error {
+	return d.acker.Nack(requeue)
+}
+
+// Publisher is the publishing half of Broker, which BatchPublisher also
+// implements.
+type Publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg Message) error
+}
+
+// Publishing is a message together with where to publish it.
+type Publishing struct {
+	Exchange string
+	Key      string
+	Message  Message
+}
+
+// BatchBroker is implemented by brokers that can publish several messages
+// faster than one at a time.
+type BatchBroker interface {
+	PublishBatch(ctx context.Context, batch []Publishing) error
+}
+
+// Broker is the part of AMQP the game relies on. AMQPBroker talks to a real
+// RabbitMQ server, MemoryBroker runs the same semantics in-process.
+type Broker interface {
+	DeclareExchange(name, kind string, durable bool) error
+	DeclareQueue(name string, queueType SimpleQueueType, args Table) (Queue, error)
+	BindQueue(queueName, key, exchange string) error
+	Publisher
+	Consume(queueName, consumerTag string, prefetch int) (<-chan RawDelivery, error)
+	// Get fetches one message, if there is one, without starting a consumer.
+	Get(queueName string) (RawDelivery, bool, error)
+	// Cancel stops the consumer. Its delivery channel is closed once the
+	// deliveries already sent to it have gone out.
+	Cancel(consumerTag string) error
+	Close() error
+}
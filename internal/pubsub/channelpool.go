@@ -0,0 +1,198 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const defaultMaxChannels = 16
+
+// channelPool hands out publishing channels, one user at a time, since an
+// amqp.Channel can't take concurrent publishes. Channels the broker closed
+// (after a precondition failure, say) or that belong to a connection that
+// has since been replaced are thrown away on the way in or out. At most
+// cap(slots) channels are open at once; callers wait for one beyond that.
+type channelPool struct {
+	b     *AMQPBroker
+	slots chan struct{}
+	idle  chan *pooledChannel
+}
+
+type pooledChannel struct {
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	returns chan amqp.Return
+}
+
+func newChannelPool(b *AMQPBroker, max int) *channelPool {
+	return &channelPool{
+		b:     b,
+		slots: make(chan struct{}, max),
+		idle:  make(chan *pooledChannel, max),
+	}
+}
+
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	for {
+		conn, err := p.b.connection()
+		if err != nil {
+			return nil, err
+		}
+		var pc *pooledChannel
+		select {
+		case pc = <-p.idle:
+		default:
+			select {
+			case pc = <-p.idle:
+			case p.slots <- struct{}{}:
+				pc, err = p.open(conn)
+				if err != nil {
+					<-p.slots
+					return nil, err
+				}
+				return pc, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p.b.done:
+				return nil, ErrClosed
+			}
+		}
+		if pc.usable(conn) {
+			return pc, nil
+		}
+		p.discard(pc)
+	}
+}
+
+// put returns pc to the pool, or closes it if it is no longer usable.
+func (p *channelPool) put(pc *pooledChannel) {
+	conn, err := p.b.connection()
+	if err != nil || !pc.usable(conn) {
+		p.discard(pc)
+		return
+	}
+	p.idle <- pc
+}
+
+func (p *channelPool) discard(pc *pooledChannel) {
+	pc.ch.Close()
+	<-p.slots
+}
+
+// dedicated opens a channel outside the pool that still counts against its
+// cap, for uses that leave a channel in a state no one else can share.
+func (p *channelPool) dedicated(ctx context.Context) (*amqp.Channel, func(), error) {
+	conn, err := p.b.connection()
+	if err != nil {
+		return nil, nil, err
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-p.b.done:
+		return nil, nil, ErrClosed
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		<-p.slots
+		return nil, nil, err
+	}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ch.Close()
+			<-p.slots
+		})
+	}, nil
+}
+
+func (p *channelPool) open(conn *amqp.Connection) (*pooledChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledChannel{conn: conn, ch: ch}
+	if p.b.confirms {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, err
+		}
+		pc.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	p.b.watchFlow(conn, ch)
+	return pc, nil
+}
+
+func (pc *pooledChannel) usable(conn *amqp.Connection) bool {
+	return pc.conn == conn && !pc.ch.IsClosed()
+}
+
+// ChannelPublisher publishes on one pooled channel, for a goroutine that
+// publishes often enough to want to hold on to it. It is not safe for
+// concurrent use. If the broker closes the channel, the next publish picks
+// up a fresh one.
+type ChannelPublisher struct {
+	b  *AMQPBroker
+	pc *pooledChannel
+}
+
+// Channel takes a channel from the pool until Release is called. It waits
+// while every channel the broker may open is in use.
+func (b *AMQPBroker) Channel(ctx context.Context) (*ChannelPublisher, error) {
+	pc, err := b.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ChannelPublisher{b: b, pc: pc}, nil
+}
+
+func (c *ChannelPublisher) Publish(ctx context.Context, exchange, key string, msg Message) error {
+	if err := c.b.waitFlow(ctx); err != nil {
+		return err
+	}
+	if err := c.refresh(ctx); err != nil {
+		return err
+	}
+	return c.b.publishOn(ctx, c.pc, exchange, key, msg)
+}
+
+func (c *ChannelPublisher) PublishBatch(ctx context.Context, batch []Publishing) error {
+	if err := c.b.waitFlow(ctx); err != nil {
+		return err
+	}
+	if err := c.refresh(ctx); err != nil {
+		return err
+	}
+	return c.b.publishBatchOn(ctx, c.pc, batch)
+}
+
+// Release hands the channel back to the pool.
+func (c *ChannelPublisher) Release() {
+	if c.pc != nil {
+		c.b.pool.put(c.pc)
+		c.pc = nil
+	}
+}
+
+func (c *ChannelPublisher) refresh(ctx context.Context) error {
+	if c.pc != nil {
+		conn, err := c.b.connection()
+		if err != nil {
+			return err
+		}
+		if c.pc.usable(conn) {
+			return nil
+		}
+		c.b.pool.discard(c.pc)
+		c.pc = nil
+	}
+	pc, err := c.b.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	c.pc = pc
+	return nil
+}
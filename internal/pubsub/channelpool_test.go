@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChannelPoolCap(t *testing.T) {
+	b := dialTest(t, WithMaxChannels(1))
+	ctx := context.Background()
+
+	held, err := b.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.Channel(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Channel beyond the cap = %v, want DeadlineExceeded", err)
+	}
+	if _, _, err := b.pool.dedicated(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dedicated beyond the cap = %v, want DeadlineExceeded", err)
+	}
+
+	held.Release()
+	next, err := b.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Release()
+}
+
+func TestChannelPoolReuse(t *testing.T) {
+	b := dialTest(t)
+	ctx := context.Background()
+
+	c, err := b.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := c.pc.ch
+	c.Release()
+	c, err = b.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Release()
+	if c.pc.ch != first {
+		t.Fatal("the released channel wasn't handed out again")
+	}
+}
+
+func TestChannelPublisherReplacesClosedChannel(t *testing.T) {
+	b := dialTest(t, WithMaxChannels(1), WithConfirms())
+	ctx := context.Background()
+	queue, err := b.DeclareQueue("", Transient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := b.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Release()
+	closed := c.pc.ch
+	closed.Close()
+	// The closed channel gives its slot back, or this would wait forever
+	// under a cap of one.
+	if err := c.Publish(ctx, "", queue.Name, Message{Body: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if c.pc.ch == closed {
+		t.Fatal("published on the closed channel")
+	}
+	waitForMessage(t, b, queue.Name)
+}
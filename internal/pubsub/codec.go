@@ -0,0 +1,156 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec turns values into message bodies and back. Subscribers pick the
+// codec from the delivery's ContentType, so every content type published
+// on the broker needs a registered codec on the consuming side.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	CBOR     Codec = cborCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+var ErrUnknownContentType = errors.New("pubsub: no codec registered for content type")
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{JSON, Gob, MsgPack, CBOR, Protobuf} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec makes c available to subscribers, replacing any codec
+// already registered for the same content type.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+func CodecFor(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// protobufCodec only handles generated message types. Subscribers decode
+// into a *T, so when T is itself a message pointer it is allocated here.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("pubsub: %T is not a protobuf message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Pointer {
+		elem := rv.Elem()
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if m, ok := elem.Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, m)
+		}
+	}
+	return fmt.Errorf("pubsub: %T is not a protobuf message", v)
+}
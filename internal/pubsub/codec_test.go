@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecPayload struct {
+	Player string
+	Units  []int
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	want := codecPayload{Player: "alice", Units: []int{1, 2}}
+	for _, codec := range []Codec{JSON, Gob, MsgPack, CBOR} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			data, err := codec.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got codecPayload
+			if err := codec.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	data, err := Protobuf.Marshal(wrapperspb.String("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subscribers decode into a *T, so a message pointer arrives as a
+	// pointer to a nil pointer.
+	var got *wrapperspb.StringValue
+	if err := Protobuf.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetValue() != "alice" {
+		t.Fatalf("got %q, want alice", got.GetValue())
+	}
+	if _, err := Protobuf.Marshal(codecPayload{}); err == nil {
+		t.Fatal("marshalled a struct that isn't a protobuf message")
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	for _, codec := range []Codec{JSON, Gob, MsgPack, CBOR, Protobuf} {
+		got, err := CodecFor(codec.ContentType())
+		if err != nil || got != codec {
+			t.Errorf("CodecFor(%q) = %v, %v", codec.ContentType(), got, err)
+		}
+	}
+	if _, err := CodecFor("text/plain"); !errors.Is(err, ErrUnknownContentType) {
+		t.Errorf("CodecFor(text/plain) = %v, want ErrUnknownContentType", err)
+	}
+}
+
+// bangCodec sends strings as they are and decodes them with a "!" added,
+// so a test can tell it was the codec used.
+type bangCodec struct{}
+
+func (bangCodec) ContentType() string { return "text/x-bang" }
+
+func (bangCodec) Marshal(v any) ([]byte, error) {
+	return []byte(v.(string)), nil
+}
+
+func (bangCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = string(data) + "!"
+	return nil
+}
+
+func TestSubscribeDispatchesOnContentType(t *testing.T) {
+	RegisterCodec(bangCodec{})
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan Delivery[string], 10)
+	sub, err := Subscribe(context.Background(), b, "ex", "q", "#", Transient, func(d Delivery[string]) SimpleAckType {
+		got <- d
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// One subscription takes every registered content type.
+	codecs := []Codec{JSON, Gob, MsgPack, CBOR, bangCodec{}}
+	for _, codec := range codecs {
+		if err := Publish(context.Background(), b, codec, "ex", "k", "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, codec := range codecs {
+		select {
+		case d := <-got:
+			want := "hello"
+			if codec == (bangCodec{}) {
+				want = "hello!"
+			}
+			if d.ContentType != codec.ContentType() || d.Value != want {
+				t.Errorf("got %q as %s, want %q as %s", d.Value, d.ContentType, want, codec.ContentType())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nothing decoded with %s", codec.ContentType())
+		}
+	}
+}
+
+func TestSubscribeDeadLettersUndecodable(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"unknown content type", Message{ContentType: "text/plain", Body: []byte("hello")}},
+		{"bad body", Message{ContentType: JSON.ContentType(), Body: []byte("{")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryServer().Connect()
+			defer b.Close()
+			declare(t, b, DeadLetterExchange, ExchangeFanout, "dead", "", nil)
+			if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+				t.Fatal(err)
+			}
+			sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+				func(string) SimpleAckType {
+					t.Error("the handler got a message it couldn't have decoded")
+					return Ack
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+			if err := b.Publish(context.Background(), "ex", "k", tt.msg); err != nil {
+				t.Fatal(err)
+			}
+			d := waitForMessage(t, b, "dead")
+			if d.ContentType != tt.msg.ContentType || string(d.Body) != string(tt.msg.Body) {
+				t.Fatalf("dead-lettered %s %q", d.ContentType, d.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package pubsub
+
+import (
+	"time"
+)
+
+// Death is one entry of the x-death header the broker adds when it
+// dead-letters a message.
+type Death struct {
+	Count       int64
+	Reason      string
+	Queue       string
+	Exchange    string
+	RoutingKeys []string
+	Time        time.Time
+}
+
+// Deaths parses the x-death header, most recent death first. The last entry
+// records where the message was originally published.
+func Deaths(headers Table) []Death {
+	entries, _ := headers["x-death"].([]any)
+	deaths := make([]Death, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.(Table)
+		if !ok {
+			continue
+		}
+		d := Death{}
+		d.Count, _ = entry["count"].(int64)
+		d.Reason, _ = entry["reason"].(string)
+		d.Queue, _ = entry["queue"].(string)
+		d.Exchange, _ = entry["exchange"].(string)
+		d.Time, _ = entry["time"].(time.Time)
+		keys, _ := entry["routing-keys"].([]any)
+		for _, k := range keys {
+			if key, ok := k.(string); ok {
+				d.RoutingKeys = append(d.RoutingKeys, key)
+			}
+		}
+		deaths = append(deaths, d)
+	}
+	return deaths
+}
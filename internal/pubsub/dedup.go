@@ -0,0 +1,171 @@
+package pubsub
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DedupStore remembers which message IDs have already been handled.
+type DedupStore interface {
+	Seen(messageID string) (bool, error)
+	Mark(messageID string) error
+}
+
+// WithDeduplication acks messages whose ID is already in store without
+// calling the handler, and records every message the handler acks. Messages
+// without an ID are always handled.
+func WithDeduplication[T any](store DedupStore) SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.dedup = store
+	}
+}
+
+// MemoryDedupStore is an LRU of message IDs. IDs are forgotten once they are
+// older than the TTL or pushed out by newer ones.
+type MemoryDedupStore struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	id     string
+	marked time.Time
+}
+
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryDedupStore) Seen(messageID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[messageID]
+	if !ok {
+		return false, nil
+	}
+	if time.Since(el.Value.(dedupEntry).marked) > s.ttl {
+		s.order.Remove(el)
+		delete(s.entries, messageID)
+		return false, nil
+	}
+	s.order.MoveToFront(el)
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Mark(messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := dedupEntry{id: messageID, marked: time.Now()}
+	if el, ok := s.entries[messageID]; ok {
+		el.Value = entry
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.entries[messageID] = s.order.PushFront(entry)
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(dedupEntry).id)
+	}
+	return nil
+}
+
+var dedupBucket = []byte("processed")
+
+// BoltDedupStore keeps message IDs in a BoltDB file so duplicates are still
+// caught after a restart. Expired IDs are pruned when the store is opened
+// and then every TTL.
+type BoltDedupStore struct {
+	db  *bolt.DB
+	ttl time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func OpenBoltDedupStore(path string, ttl time.Duration) (*BoltDedupStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dedupBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &BoltDedupStore{db: db, ttl: ttl}
+	if err := s.Prune(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltDedupStore) Seen(messageID string) (bool, error) {
+	seen := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(dedupBucket).Get([]byte(messageID))
+		if len(v) == 8 {
+			marked := time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+			seen = time.Since(marked) <= s.ttl
+		}
+		return nil
+	})
+	return seen, err
+}
+
+func (s *BoltDedupStore) Mark(messageID string) error {
+	var v [8]byte
+	binary.BigEndian.PutUint64(v[:], uint64(time.Now().UnixNano()))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(dedupBucket).Put([]byte(messageID), v[:])
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	due := time.Since(s.lastPruned) > s.ttl
+	s.mu.Unlock()
+	if due {
+		return s.Prune()
+	}
+	return nil
+}
+
+// Prune deletes every ID older than the TTL.
+func (s *BoltDedupStore) Prune() error {
+	s.mu.Lock()
+	s.lastPruned = time.Now()
+	s.mu.Unlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(dedupBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) != 8 || time.Since(time.Unix(0, int64(binary.BigEndian.Uint64(v)))) > s.ttl {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltDedupStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	s := NewMemoryDedupStore(2, time.Hour)
+	mark(t, s, "a")
+	mark(t, s, "b")
+	assertSeen(t, s, "a", true)
+	// a was just seen, so c pushes out b.
+	mark(t, s, "c")
+	assertSeen(t, s, "b", false)
+	assertSeen(t, s, "a", true)
+	assertSeen(t, s, "c", true)
+
+	expiring := NewMemoryDedupStore(10, time.Millisecond)
+	mark(t, expiring, "a")
+	time.Sleep(5 * time.Millisecond)
+	assertSeen(t, expiring, "a", false)
+}
+
+func TestBoltDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	s, err := OpenBoltDedupStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark(t, s, "a")
+	assertSeen(t, s, "b", false)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenBoltDedupStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	assertSeen(t, s, "a", true)
+}
+
+func TestBoltDedupStoreExpires(t *testing.T) {
+	s, err := OpenBoltDedupStore(filepath.Join(t.TempDir(), "dedup.db"), time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mark(t, s, "a")
+	time.Sleep(5 * time.Millisecond)
+	assertSeen(t, s, "a", false)
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithDeduplication(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan string, 10)
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(s string) SimpleAckType {
+			handled <- s
+			if s == "rejected" {
+				return NackDiscard
+			}
+			return Ack
+		},
+		WithDeduplication[string](NewMemoryDedupStore(10, time.Hour)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// The consumer has one worker, so messages are handled in order and
+	// "done" comes last.
+	for _, m := range []struct{ id, body string }{
+		{"1", "first"},
+		{"1", "first again"},
+		{"2", "rejected"},
+		{"2", "rejected again"},
+		{"", "no id"},
+		{"", "no id again"},
+		{"3", "done"},
+	} {
+		if err := PublishJSON(b, "ex", "k", m.body, withMessageID(m.id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for len(got) == 0 || got[len(got)-1] != "done" {
+		select {
+		case s := <-handled:
+			got = append(got, s)
+		case <-time.After(time.Second):
+			t.Fatalf("handled %v, never got to done", got)
+		}
+	}
+	want := []string{"first", "rejected", "rejected again", "no id", "no id again", "done"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled %v, want %v", got, want)
+	}
+}
+
+func withMessageID(id string) PublishOption {
+	return func(msg *Message) {
+		msg.MessageID = id
+	}
+}
+
+func mark(t *testing.T, s DedupStore, id string) {
+	t.Helper()
+	if err := s.Mark(id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertSeen(t *testing.T, s DedupStore, id string, want bool) {
+	t.Helper()
+	seen, err := s.Seen(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != want {
+		t.Fatalf("Seen(%q) = %v, want %v", id, seen, want)
+	}
+}
@@ -0,0 +1,115 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const schemaVersionHeader = "x-schema-version"
+
+// AppID identifies this process on everything it publishes. It defaults to
+// the program name; clients usually set it to something more specific.
+var AppID = filepath.Base(os.Args[0])
+
+// SchemaVersioned payloads publish their own schema version. Everything
+// else is version 1.
+type SchemaVersioned interface {
+	SchemaVersion() int
+}
+
+// Delivery is a decoded message together with its envelope.
+type Delivery[T any] struct {
+	Value T
+
+	MessageID     string
+	CorrelationID string
+	ReplyTo       string
+	ContentType   string
+	AppID         string
+	Timestamp     time.Time
+	SchemaVersion int
+
+	// Exchange and RoutingKey are where the message was first published,
+	// even once it has been through retry queues.
+	Exchange    string
+	RoutingKey  string
+	Redelivered bool
+	// Attempts is how many times the message has been retried already.
+	Attempts int
+	Headers  Table
+
+	ctx context.Context
+}
+
+// Context carries the trace the message was published in, so that
+// publishing from a handler with it continues the same trace.
+func (d Delivery[T]) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+func newDelivery[T any](d RawDelivery, v T) Delivery[T] {
+	version := 1
+	switch n := d.Headers[schemaVersionHeader].(type) {
+	case int64:
+		version = int(n)
+	case int32:
+		version = int(n)
+	case int:
+		version = n
+	}
+	exchange, key := OriginalRoute(d)
+	return Delivery[T]{
+		Value:         v,
+		MessageID:     d.MessageID,
+		CorrelationID: d.CorrelationID,
+		ReplyTo:       d.ReplyTo,
+		ContentType:   d.ContentType,
+		AppID:         d.AppID,
+		Timestamp:     d.Timestamp,
+		SchemaVersion: version,
+		Exchange:      exchange,
+		RoutingKey:    key,
+		Redelivered:   d.Redelivered,
+		Attempts:      Attempts(d.Headers),
+		Headers:       d.Headers,
+	}
+}
+
+// envelope stamps msg with a fresh ID, the current time, AppID and the
+// schema version of val. Publish options run afterwards and may override it.
+func envelope(msg *Message, val any) {
+	version := 1
+	if v, ok := val.(SchemaVersioned); ok {
+		version = v.SchemaVersion()
+	}
+	if msg.Headers == nil {
+		msg.Headers = Table{}
+	}
+	msg.Headers[schemaVersionHeader] = int64(version)
+	msg.MessageID = NewMessageID()
+	msg.Timestamp = time.Now()
+	msg.AppID = AppID
+}
+
+// NewMessageID returns a random UUID.
+func NewMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func WithCorrelationID(id string) PublishOption {
+	return func(msg *Message) {
+		msg.CorrelationID = id
+	}
+}
@@ -0,0 +1,139 @@
+package pubsub
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type versionedPayload struct {
+	Player string
+}
+
+func (versionedPayload) SchemaVersion() int { return 3 }
+
+func TestPublishStampsEnvelope(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+
+	before := time.Now()
+	if err := PublishJSON(b, "ex", "k", versionedPayload{Player: "alice"}, WithCorrelationID("call-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(b, "ex", "k", "unversioned"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok, err := b.Get("q")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(d.MessageID) {
+		t.Errorf("MessageID = %q, want a UUID", d.MessageID)
+	}
+	if d.Timestamp.Before(before.Truncate(time.Second)) || d.Timestamp.After(time.Now()) {
+		t.Errorf("Timestamp = %v, want about %v", d.Timestamp, before)
+	}
+	if d.AppID != AppID {
+		t.Errorf("AppID = %q, want %q", d.AppID, AppID)
+	}
+	if d.CorrelationID != "call-1" {
+		t.Errorf("CorrelationID = %q, want call-1", d.CorrelationID)
+	}
+	if v := newDelivery(d, versionedPayload{}).SchemaVersion; v != 3 {
+		t.Errorf("SchemaVersion = %d, want 3", v)
+	}
+
+	d, ok, err = b.Get("q")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if v := newDelivery(d, "").SchemaVersion; v != 1 {
+		t.Errorf("unversioned SchemaVersion = %d, want 1", v)
+	}
+	if d.MessageID == "" || d.CorrelationID != "" {
+		t.Errorf("second message has ID %q, correlation ID %q", d.MessageID, d.CorrelationID)
+	}
+}
+
+func TestNewDelivery(t *testing.T) {
+	now := time.Now()
+	raw := RawDelivery{
+		Message: Message{
+			ContentType:   JSON.ContentType(),
+			MessageID:     "id",
+			CorrelationID: "corr",
+			ReplyTo:       "replies",
+			AppID:         "peril_client.alice",
+			Timestamp:     now,
+			Headers:       Table{retryAttemptHeader: int64(2)},
+		},
+		Exchange:    "ex",
+		RoutingKey:  "moves.alice",
+		Redelivered: true,
+	}
+	d := newDelivery(raw, "hello")
+	if d.Value != "hello" || d.MessageID != "id" || d.CorrelationID != "corr" || d.ReplyTo != "replies" ||
+		d.AppID != "peril_client.alice" || !d.Timestamp.Equal(now) || d.ContentType != JSON.ContentType() ||
+		d.Exchange != "ex" || d.RoutingKey != "moves.alice" || !d.Redelivered || d.Attempts != 2 {
+		t.Fatalf("newDelivery = %+v", d)
+	}
+
+	// After a retry hop the message comes from the retry queue through the
+	// default exchange.
+	retried := raw
+	retried.Exchange, retried.RoutingKey = "", "q.retry.10ms"
+	retried.Headers = Table{
+		retryAttemptHeader:     int64(1),
+		originalExchangeHeader: "ex",
+		originalKeyHeader:      "moves.alice",
+	}
+	if d := newDelivery(retried, ""); d.Exchange != "ex" || d.RoutingKey != "moves.alice" {
+		t.Errorf("retried delivery came from %q %q, want ex moves.alice", d.Exchange, d.RoutingKey)
+	}
+
+	// AMQP hands integer headers back in whatever width they were sent.
+	for _, version := range []any{int64(2), int32(2), 2} {
+		raw.Headers = Table{schemaVersionHeader: version}
+		if got := newDelivery(raw, "").SchemaVersion; got != 2 {
+			t.Errorf("schema version header %T(2) gives version %d", version, got)
+		}
+	}
+	raw.Headers = nil
+	if got := newDelivery(raw, "").SchemaVersion; got != 1 {
+		t.Errorf("no schema version header gives version %d, want 1", got)
+	}
+}
+
+func TestSubscribeHandsOverEnvelope(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan Delivery[versionedPayload], 1)
+	sub, err := Subscribe(context.Background(), b, "ex", "q", "moves.*", Transient,
+		func(d Delivery[versionedPayload]) SimpleAckType {
+			got <- d
+			return Ack
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := PublishJSON(b, "ex", "moves.alice", versionedPayload{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-got:
+		if d.Value.Player != "alice" || d.SchemaVersion != 3 || d.MessageID == "" ||
+			d.Exchange != "ex" || d.RoutingKey != "moves.alice" || d.AppID != AppID {
+			t.Fatalf("got %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+}
@@ -0,0 +1,662 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryServer is an in-process stand-in for a RabbitMQ server. Every call
+// to Connect returns a MemoryBroker that behaves like its own AMQP
+// connection: transient queues belong to the connection that declared them
+// and disappear with it, durable queues outlive it.
+type MemoryServer struct {
+	mu        sync.Mutex
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	nextTag   uint64
+	nextSeq   uint64
+	nextName  int
+}
+
+type memExchange struct {
+	name     string
+	kind     string
+	durable  bool
+	bindings []memBinding
+}
+
+type memBinding struct {
+	key   string
+	queue *memQueue
+}
+
+type memQueue struct {
+	name        string
+	durable     bool
+	owner       *MemoryBroker
+	args        Table
+	ready       []memMessage
+	consumers   []*memConsumer
+	next        int
+	hadConsumer bool
+}
+
+type memMessage struct {
+	seq         uint64
+	msg         Message
+	exchange    string
+	key         string
+	redelivered bool
+}
+
+type memConsumer struct {
+	conn      *MemoryBroker
+	tag       string
+	queue     *memQueue
+	prefetch  int
+	unacked   map[uint64]memMessage
+	pending   []RawDelivery
+	cancelled bool
+	out       chan RawDelivery
+	wake      chan struct{}
+	done      chan struct{}
+}
+
+func NewMemoryServer() *MemoryServer {
+	return &MemoryServer{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+	}
+}
+
+func (s *MemoryServer) Connect() *MemoryBroker {
+	return &MemoryBroker{
+		server:    s,
+		consumers: map[*memConsumer]struct{}{},
+		getters:   map[*memQueue]*memConsumer{},
+	}
+}
+
+// MemoryBroker is a single connection to a MemoryServer.
+type MemoryBroker struct {
+	server    *MemoryServer
+	closed    bool
+	consumers map[*memConsumer]struct{}
+	getters   map[*memQueue]*memConsumer
+}
+
+func (b *MemoryBroker) DeclareExchange(name, kind string, durable bool) error {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if name == "" {
+		return errors.New("pubsub: cannot redeclare the default exchange")
+	}
+	switch kind {
+	case ExchangeDirect, ExchangeTopic, ExchangeFanout:
+	default:
+		return fmt.Errorf("pubsub: unsupported exchange kind %q", kind)
+	}
+	if ex, ok := s.exchanges[name]; ok {
+		if ex.kind != kind || ex.durable != durable {
+			return fmt.Errorf("pubsub: exchange %q already declared with different settings", name)
+		}
+		return nil
+	}
+	s.exchanges[name] = &memExchange{name: name, kind: kind, durable: durable}
+	return nil
+}
+
+func (b *MemoryBroker) DeclareQueue(name string, queueType SimpleQueueType, args Table) (Queue, error) {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return Queue{}, ErrClosed
+	}
+	if name == "" {
+		s.nextName++
+		name = fmt.Sprintf("amq.gen-%d", s.nextName)
+	}
+	durable := queueType == Durable
+	if q, ok := s.queues[name]; ok {
+		if q.owner != nil && q.owner != b {
+			return Queue{}, fmt.Errorf("pubsub: queue %q is exclusive to another connection", name)
+		}
+		if q.durable != durable {
+			return Queue{}, fmt.Errorf("pubsub: queue %q already declared with different durability", name)
+		}
+		return q.info(), nil
+	}
+	q := &memQueue{name: name, durable: durable, args: copyTable(args)}
+	if !durable {
+		q.owner = b
+	}
+	s.queues[name] = q
+	return q.info(), nil
+}
+
+func (b *MemoryBroker) BindQueue(queueName, key, exchange string) error {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	q, ok := s.queues[queueName]
+	if !ok {
+		return fmt.Errorf("pubsub: no queue %q", queueName)
+	}
+	ex, ok := s.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("pubsub: no exchange %q", exchange)
+	}
+	for _, bind := range ex.bindings {
+		if bind.queue == q && bind.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{key: key, queue: q})
+	return nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, exchange, key string, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	routed, err := s.route(exchange, key, memMessage{msg: msg, exchange: exchange, key: key})
+	if err != nil {
+		return err
+	}
+	if routed == 0 && msg.Mandatory {
+		return &UnroutableError{Exchange: exchange, RoutingKey: key, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Consume(queueName, consumerTag string, prefetch int) (<-chan RawDelivery, error) {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	q, ok := s.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("pubsub: no queue %q", queueName)
+	}
+	if q.owner != nil && q.owner != b {
+		return nil, fmt.Errorf("pubsub: queue %q is exclusive to another connection", queueName)
+	}
+	for c := range b.consumers {
+		if c.tag == consumerTag && !c.cancelled {
+			return nil, fmt.Errorf("pubsub: consumer tag %q already in use", consumerTag)
+		}
+	}
+	c := &memConsumer{
+		conn:     b,
+		tag:      consumerTag,
+		queue:    q,
+		prefetch: prefetch,
+		unacked:  map[uint64]memMessage{},
+		out:      make(chan RawDelivery),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	q.consumers = append(q.consumers, c)
+	q.hadConsumer = true
+	b.consumers[c] = struct{}{}
+	go c.pump(s)
+	s.dispatch(q)
+	return c.out, nil
+}
+
+func (b *MemoryBroker) Get(queueName string) (RawDelivery, bool, error) {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return RawDelivery{}, false, ErrClosed
+	}
+	q, ok := s.queues[queueName]
+	if !ok {
+		return RawDelivery{}, false, fmt.Errorf("pubsub: no queue %q", queueName)
+	}
+	if q.owner != nil && q.owner != b {
+		return RawDelivery{}, false, fmt.Errorf("pubsub: queue %q is exclusive to another connection", queueName)
+	}
+	if len(q.ready) == 0 {
+		return RawDelivery{}, false, nil
+	}
+	// Gets are tracked like a consumer so that settling and Close work the
+	// same way, but it is never attached to the queue.
+	c, ok := b.getters[q]
+	if !ok {
+		c = &memConsumer{
+			conn:      b,
+			queue:     q,
+			unacked:   map[uint64]memMessage{},
+			cancelled: true,
+			done:      make(chan struct{}),
+		}
+		b.getters[q] = c
+		b.consumers[c] = struct{}{}
+	}
+	m := q.ready[0]
+	q.ready = q.ready[1:]
+	s.nextTag++
+	c.unacked[s.nextTag] = m
+	return RawDelivery{
+		Message:     m.msg,
+		Exchange:    m.exchange,
+		RoutingKey:  m.key,
+		Redelivered: m.redelivered,
+		acker:       memAcker{server: s, consumer: c, tag: s.nextTag},
+	}, true, nil
+}
+
+// Cancel stops deliveries to the consumer. Deliveries already handed to it
+// are still sent on its channel, which closes once they are all out; they
+// can be settled as usual. Once the broker is closed there is nothing left
+// to cancel.
+func (b *MemoryBroker) Cancel(consumerTag string) error {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	for c := range b.consumers {
+		if c.tag != consumerTag || c.cancelled {
+			continue
+		}
+		c.cancelled = true
+		s.removeConsumer(c)
+		c.signal()
+		return nil
+	}
+	return fmt.Errorf("pubsub: no consumer %q", consumerTag)
+}
+
+func (b *MemoryBroker) Close() error {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for c := range b.consumers {
+		close(c.done)
+		if !c.cancelled {
+			s.removeConsumer(c)
+		}
+		s.requeueUnacked(c)
+	}
+	b.consumers = nil
+	for _, q := range s.queues {
+		if q.owner == b {
+			s.deleteQueue(q)
+		}
+	}
+	return nil
+}
+
+func (q *memQueue) info() Queue {
+	return Queue{Name: q.name, Messages: len(q.ready), Consumers: len(q.consumers)}
+}
+
+// InspectTopology reports the exchanges, queues and bindings declared on
+// the server, sorted by name.
+func (b *MemoryBroker) InspectTopology() (Topology, error) {
+	s := b.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.closed {
+		return Topology{}, ErrClosed
+	}
+	var t Topology
+	for _, ex := range s.exchanges {
+		t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: ex.name, Kind: ex.kind, Durable: ex.durable})
+		for _, bind := range ex.bindings {
+			t.Bindings = append(t.Bindings, BindingSpec{Exchange: ex.name, Queue: bind.queue.name, Key: bind.key})
+		}
+	}
+	for _, q := range s.queues {
+		t.Queues = append(t.Queues, QueueSpec{Name: q.name, Durable: q.durable, Arguments: copyTable(q.args)})
+	}
+	sort.Slice(t.Exchanges, func(i, j int) bool { return t.Exchanges[i].Name < t.Exchanges[j].Name })
+	sort.Slice(t.Queues, func(i, j int) bool { return t.Queues[i].Name < t.Queues[j].Name })
+	sort.Slice(t.Bindings, func(i, j int) bool { return t.Bindings[i].String() < t.Bindings[j].String() })
+	return t, nil
+}
+
+func (s *MemoryServer) route(exchange, key string, m memMessage) (int, error) {
+	var targets []*memQueue
+	if exchange == "" {
+		if q, ok := s.queues[key]; ok {
+			targets = append(targets, q)
+		}
+	} else {
+		ex, ok := s.exchanges[exchange]
+		if !ok {
+			return 0, fmt.Errorf("pubsub: no exchange %q", exchange)
+		}
+		seen := map[*memQueue]bool{}
+		for _, bind := range ex.bindings {
+			if seen[bind.queue] || !ex.matches(bind.key, key) {
+				continue
+			}
+			seen[bind.queue] = true
+			targets = append(targets, bind.queue)
+		}
+	}
+	for _, q := range targets {
+		copied := m
+		copied.msg.Headers = copyTable(m.msg.Headers)
+		s.nextSeq++
+		copied.seq = s.nextSeq
+		q.ready = append(q.ready, copied)
+		if ttl, ok := q.ttl(); ok {
+			seq := copied.seq
+			time.AfterFunc(ttl, func() { s.expire(q, seq) })
+		}
+		s.dispatch(q)
+	}
+	return len(targets), nil
+}
+
+func (q *memQueue) ttl() (time.Duration, bool) {
+	var ms int64
+	switch v := q.args["x-message-ttl"].(type) {
+	case int64:
+		ms = v
+	case int32:
+		ms = int64(v)
+	case int:
+		ms = int64(v)
+	default:
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// expire dead-letters a message whose TTL ran out while it was still
+// waiting in the queue. Messages already handed to a consumer are left alone.
+func (s *MemoryServer) expire(q *memQueue, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, m := range q.ready {
+		if m.seq == seq {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			s.deadLetter(q, m, "expired")
+			return
+		}
+	}
+}
+
+func (ex *memExchange) matches(bindingKey, routingKey string) bool {
+	switch ex.kind {
+	case ExchangeFanout:
+		return true
+	case ExchangeTopic:
+		return topicMatch(bindingKey, routingKey)
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+// dispatch hands ready messages to consumers round-robin, respecting each
+// consumer's prefetch window. Callers must hold s.mu.
+func (s *MemoryServer) dispatch(q *memQueue) {
+	for len(q.ready) > 0 {
+		c := q.nextConsumer()
+		if c == nil {
+			return
+		}
+		m := q.ready[0]
+		q.ready = q.ready[1:]
+		s.nextTag++
+		tag := s.nextTag
+		c.unacked[tag] = m
+		c.pending = append(c.pending, RawDelivery{
+			Message:     m.msg,
+			Exchange:    m.exchange,
+			RoutingKey:  m.key,
+			Redelivered: m.redelivered,
+			acker:       memAcker{server: s, consumer: c, tag: tag},
+		})
+		c.signal()
+	}
+}
+
+func (q *memQueue) nextConsumer() *memConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		c := q.consumers[(q.next+i)%len(q.consumers)]
+		if c.prefetch == 0 || len(c.unacked) < c.prefetch {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *MemoryServer) deadLetter(q *memQueue, m memMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := m.key
+	if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+	headers := copyTable(m.msg.Headers)
+	if headers == nil {
+		headers = Table{}
+	}
+	headers["x-death"] = appendDeath(headers["x-death"], Table{
+		"count":        int64(1),
+		"reason":       reason,
+		"queue":        q.name,
+		"time":         time.Now(),
+		"exchange":     m.exchange,
+		"routing-keys": []any{m.key},
+	})
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-exchange"] = m.exchange
+	}
+	msg := m.msg
+	msg.Headers = headers
+	s.route(dlx, key, memMessage{msg: msg, exchange: dlx, key: key})
+}
+
+// appendDeath mirrors RabbitMQ's x-death bookkeeping: one entry per
+// queue/reason pair, most recent first, with a running count.
+func appendDeath(existing any, death Table) []any {
+	deaths, _ := existing.([]any)
+	for i, d := range deaths {
+		entry, ok := d.(Table)
+		if !ok || entry["queue"] != death["queue"] || entry["reason"] != death["reason"] {
+			continue
+		}
+		count, _ := entry["count"].(int64)
+		death["count"] = count + 1
+		deaths = append(deaths[:i:i], deaths[i+1:]...)
+		break
+	}
+	return append([]any{death}, deaths...)
+}
+
+func (s *MemoryServer) removeConsumer(c *memConsumer) {
+	q := c.queue
+	for i, other := range q.consumers {
+		if other == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+	if q.owner != nil && q.hadConsumer && len(q.consumers) == 0 {
+		s.deleteQueue(q)
+	}
+}
+
+func (s *MemoryServer) requeueUnacked(c *memConsumer) {
+	tags := make([]uint64, 0, len(c.unacked))
+	for tag := range c.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	requeued := make([]memMessage, 0, len(tags))
+	for _, tag := range tags {
+		m := c.unacked[tag]
+		m.redelivered = true
+		requeued = append(requeued, m)
+	}
+	c.unacked = map[uint64]memMessage{}
+	c.pending = nil
+	c.queue.ready = append(requeued, c.queue.ready...)
+	s.dispatch(c.queue)
+}
+
+func (s *MemoryServer) deleteQueue(q *memQueue) {
+	if s.queues[q.name] != q {
+		return
+	}
+	delete(s.queues, q.name)
+	for _, ex := range s.exchanges {
+		kept := ex.bindings[:0]
+		for _, bind := range ex.bindings {
+			if bind.queue != q {
+				kept = append(kept, bind)
+			}
+		}
+		ex.bindings = kept
+	}
+	q.ready = nil
+}
+
+func (c *memConsumer) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *memConsumer) pump(s *MemoryServer) {
+	defer close(c.out)
+	for {
+		s.mu.Lock()
+		if len(c.pending) == 0 {
+			cancelled := c.cancelled
+			s.mu.Unlock()
+			if cancelled {
+				return
+			}
+			select {
+			case <-c.wake:
+			case <-c.done:
+				return
+			}
+			continue
+		}
+		d := c.pending[0]
+		c.pending = c.pending[1:]
+		s.mu.Unlock()
+		select {
+		case c.out <- d:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+type memAcker struct {
+	server   *MemoryServer
+	consumer *memConsumer
+	tag      uint64
+}
+
+func (a memAcker) Ack() error {
+	s := a.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := a.consumer.unacked[a.tag]; !ok {
+		return fmt.Errorf("pubsub: unknown delivery tag %d", a.tag)
+	}
+	delete(a.consumer.unacked, a.tag)
+	s.dispatch(a.consumer.queue)
+	return nil
+}
+
+func (a memAcker) Nack(requeue bool) error {
+	s := a.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := a.consumer.unacked[a.tag]
+	if !ok {
+		return fmt.Errorf("pubsub: unknown delivery tag %d", a.tag)
+	}
+	delete(a.consumer.unacked, a.tag)
+	q := a.consumer.queue
+	if requeue {
+		m.redelivered = true
+		q.ready = append([]memMessage{m}, q.ready...)
+	} else {
+		s.deadLetter(q, m, "rejected")
+	}
+	s.dispatch(q)
+	return nil
+}
+
+func copyTable(t Table) Table {
+	if t == nil {
+		return nil
+	}
+	copied := make(Table, len(t))
+	for k, v := range t {
+		copied[k] = v
+	}
+	return copied
+}
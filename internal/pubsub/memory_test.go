@@ -0,0 +1,360 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.bob", false},
+		{"*.alice", "army_moves.alice", true},
+		{"*", "", true},
+		{"#", "", true},
+		{"#", "a.b.c", true},
+		{"war.#", "war", true},
+		{"war.#", "war.alice.bob", true},
+		{"war.#", "peace.alice", false},
+		{"#.bob", "war.alice.bob", true},
+		{"#.bob", "bob", true},
+		{"a.#.c", "a.c", true},
+		{"a.#.c", "a.b.b.c", true},
+		{"a.#.c", "a.b.b", false},
+		{"a.*.#", "a", false},
+		{"a.*.#", "a.b", true},
+		{"pause", "pause", true},
+		{"pause", "paused", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryExchangeKinds(t *testing.T) {
+	tests := []struct {
+		kind, binding, key string
+		want               bool
+	}{
+		{ExchangeDirect, "pause", "pause", true},
+		{ExchangeDirect, "pause", "paused", false},
+		{ExchangeDirect, "*", "pause", false},
+		{ExchangeTopic, "*", "pause", true},
+		{ExchangeFanout, "ignored", "anything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+"/"+tt.binding+"/"+tt.key, func(t *testing.T) {
+			b := NewMemoryServer().Connect()
+			defer b.Close()
+			declare(t, b, "ex", tt.kind, "q", tt.binding, nil)
+			deliveries, err := b.Consume("q", "c", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			publish(t, b, "ex", tt.key, "hello")
+			if _, ok := tryReceive(deliveries); ok != tt.want {
+				t.Fatalf("routed = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryPrefetch(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	deliveries, err := b.Consume("q", "c", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		publish(t, b, "ex", "k", "hello")
+	}
+	first := receive(t, deliveries)
+	receive(t, deliveries)
+	select {
+	case <-deliveries:
+		t.Fatal("got a third delivery with two unacked and a prefetch of 2")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := first.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, deliveries)
+}
+
+func TestMemoryRoundRobin(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	one, err := b.Consume("q", "one", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := b.Consume("q", "two", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "ex", "k", "a")
+	publish(t, b, "ex", "k", "b")
+	receive(t, one)
+	receive(t, two)
+}
+
+func TestMemoryExclusiveQueue(t *testing.T) {
+	s := NewMemoryServer()
+	owner, other := s.Connect(), s.Connect()
+	defer other.Close()
+	declare(t, owner, "ex", ExchangeTopic, "transient", "#", nil)
+	declare(t, owner, "ex", ExchangeTopic, "durable", "#", nil, Durable)
+
+	if _, err := other.DeclareQueue("transient", Transient, nil); err == nil {
+		t.Error("declared another connection's transient queue")
+	}
+	if _, err := other.Consume("transient", "c", 0); err == nil {
+		t.Error("consumed another connection's transient queue")
+	}
+	if _, err := other.Consume("durable", "c", 0); err != nil {
+		t.Errorf("consuming a durable queue from another connection: %v", err)
+	}
+
+	if err := owner.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.DeclareQueue("transient", Transient, nil); err != nil {
+		t.Errorf("transient queue outlived its connection: %v", err)
+	}
+	if _, err := other.DeclareQueue("durable", Transient, nil); err == nil {
+		t.Error("durable queue was deleted with its connection")
+	}
+}
+
+func TestMemoryAutoDelete(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	if _, err := b.Consume("q", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Cancel("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Consume("q", "c", 0); err == nil {
+		t.Fatal("transient queue survived its last consumer")
+	}
+
+	// A queue that never had a consumer isn't deleted.
+	declare(t, b, "ex", ExchangeTopic, "unused", "#", nil)
+	if _, err := b.Consume("unused", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   Table
+		settle func(t *testing.T, b *MemoryBroker)
+		reason string
+	}{
+		{
+			name: "rejected",
+			settle: func(t *testing.T, b *MemoryBroker) {
+				d, ok, err := b.Get("q")
+				if err != nil || !ok {
+					t.Fatalf("Get = %v, %v", ok, err)
+				}
+				if err := d.Nack(false); err != nil {
+					t.Fatal(err)
+				}
+			},
+			reason: "rejected",
+		},
+		{
+			name: "expired",
+			args: Table{"x-message-ttl": int64(10)},
+			settle: func(t *testing.T, b *MemoryBroker) {
+				time.Sleep(50 * time.Millisecond)
+			},
+			reason: "expired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryServer().Connect()
+			defer b.Close()
+			declare(t, b, "dlx", ExchangeFanout, "dead", "", nil)
+			args := Table{"x-dead-letter-exchange": "dlx"}
+			for k, v := range tt.args {
+				args[k] = v
+			}
+			declare(t, b, "ex", ExchangeTopic, "q", "#", args)
+			publish(t, b, "ex", "k", "hello")
+			tt.settle(t, b)
+
+			if _, ok, _ := b.Get("q"); ok {
+				t.Fatal("message is still in its queue")
+			}
+			d, ok, err := b.Get("dead")
+			if err != nil || !ok {
+				t.Fatalf("dead-lettered message: %v, %v", ok, err)
+			}
+			if got := d.Message.Headers["x-first-death-reason"]; got != tt.reason {
+				t.Errorf("x-first-death-reason = %v, want %s", got, tt.reason)
+			}
+			deaths, _ := d.Message.Headers["x-death"].([]any)
+			if len(deaths) != 1 || deaths[0].(Table)["queue"] != "q" {
+				t.Errorf("x-death = %v", deaths)
+			}
+		})
+	}
+}
+
+func TestMemoryRequeueOnClose(t *testing.T) {
+	s := NewMemoryServer()
+	first, second := s.Connect(), s.Connect()
+	defer second.Close()
+	declare(t, first, "ex", ExchangeTopic, "q", "#", nil, Durable)
+	if _, err := first.Consume("q", "c", 0); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, first, "ex", "k", "hello")
+	first.Close()
+
+	deliveries, err := second.Consume("q", "c", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := receive(t, deliveries); !d.Redelivered {
+		t.Error("unacked message wasn't marked redelivered")
+	}
+}
+
+func TestPublishJSONRoundTrip(t *testing.T) {
+	type move struct {
+		Player string
+		To     string
+		Units  []int
+	}
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan move, 1)
+	err := SubscribeJSON(b, "ex", "moves", "moves.*", Transient, func(m move) SimpleAckType {
+		got <- m
+		return Ack
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := move{Player: "alice", To: "europe", Units: []int{1, 2}}
+	if err := PublishJSON(b, "ex", "moves.alice", want); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if m.Player != want.Player || m.To != want.To || len(m.Units) != 2 {
+			t.Fatalf("got %+v, want %+v", m, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+}
+
+func TestMandatoryUnroutable(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "moves.*", nil)
+
+	if err := PublishJSON(b, "ex", "pause", 1); err != nil {
+		t.Fatalf("unroutable publish without Mandatory: %v", err)
+	}
+	err := PublishJSON(b, "ex", "pause", 1, Mandatory())
+	var unroutable *UnroutableError
+	if !errors.Is(err, ErrUnroutable) || !errors.As(err, &unroutable) {
+		t.Fatalf("unroutable mandatory publish = %v, want an UnroutableError", err)
+	}
+	if unroutable.Exchange != "ex" || unroutable.RoutingKey != "pause" {
+		t.Fatalf("returned %s/%s", unroutable.Exchange, unroutable.RoutingKey)
+	}
+	if err := PublishJSON(b, "ex", "moves.alice", 1, Mandatory()); err != nil {
+		t.Fatalf("routable mandatory publish: %v", err)
+	}
+}
+
+// declare declares an exchange and a queue, transient unless a queue type
+// is given, and binds them.
+func declare(t *testing.T, b Broker, exchange, kind, queue, key string, args Table, queueType ...SimpleQueueType) {
+	t.Helper()
+	if err := b.DeclareExchange(exchange, kind, true); err != nil {
+		t.Fatal(err)
+	}
+	qt := Transient
+	if len(queueType) > 0 {
+		qt = queueType[0]
+	}
+	if _, err := b.DeclareQueue(queue, qt, args); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.BindQueue(queue, key, exchange); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publish(t *testing.T, b Broker, exchange, key, body string) {
+	t.Helper()
+	if err := b.Publish(context.Background(), exchange, key, Message{Body: []byte(body)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, deliveries <-chan RawDelivery) RawDelivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return RawDelivery{}
+	}
+}
+
+// tryReceive waits briefly for a delivery that may not come.
+func tryReceive(deliveries <-chan RawDelivery) (RawDelivery, bool) {
+	select {
+	case d := <-deliveries:
+		return d, true
+	case <-time.After(50 * time.Millisecond):
+		return RawDelivery{}, false
+	}
+}
+
+// waitForMessage polls queue until a message arrives.
+func waitForMessage(t *testing.T, b Broker, queue string) RawDelivery {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		d, ok, err := b.Get(queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return d
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nothing arrived in %s", queue)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
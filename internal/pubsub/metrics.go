@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the pubsub collectors, plus the Go runtime and process
+// collectors. Programs can register their own collectors on it too.
+var Metrics = prometheus.NewRegistry()
+
+// Routing keys carry player names, so the label sets grow with the number
+// of players. That's fine at Peril's scale.
+var (
+	messagesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "messages_published_total",
+		Help:      "Messages published, by exchange and routing key.",
+	}, []string{"exchange", "routing_key"})
+	publishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "publish_errors_total",
+		Help:      "Publishes that failed, by exchange and routing key.",
+	}, []string{"exchange", "routing_key"})
+	messagesHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "messages_handled_total",
+		Help:      "Deliveries handled, by queue, exchange, routing key and how the handler settled them.",
+	}, []string{"queue", "exchange", "routing_key", "ack"})
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent in subscription handlers, middleware included.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+	}, []string{"queue"})
+	decodeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "decode_failures_total",
+		Help:      "Deliveries dead-lettered because they could not be decoded.",
+	}, []string{"queue", "content_type"})
+	duplicatesSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "duplicates_skipped_total",
+		Help:      "Deliveries acked without handling because they were already processed.",
+	}, []string{"queue"})
+	reconnectAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "reconnect_attempts_total",
+		Help:      "Attempts to redial the broker after losing the connection.",
+	})
+	reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "peril",
+		Subsystem: "pubsub",
+		Name:      "reconnects_total",
+		Help:      "Successful reconnections to the broker.",
+	})
+)
+
+func init() {
+	Metrics.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		messagesPublished,
+		publishErrors,
+		messagesHandled,
+		handlerDuration,
+		decodeFailures,
+		duplicatesSkipped,
+		reconnectAttempts,
+		reconnects,
+	)
+}
+
+// ServeMetrics serves Metrics at /metrics on addr in the background. The
+// listener is opened before it returns, so a bad address is reported here.
+func ServeMetrics(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Metrics, promhttp.HandlerOpts{Registry: Metrics}))
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("metrics server stopped: %v\n", err)
+		}
+	}()
+	return srv, nil
+}
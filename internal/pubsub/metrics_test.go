@@ -0,0 +1,243 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// metricValue gathers Metrics and returns the counter value, or histogram
+// sample count, of the series of name with the given label values.
+func metricValue(t *testing.T, name string, labels ...string) float64 {
+	t.Helper()
+	families, err := Metrics.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range family.GetMetric() {
+			for i := 0; i < len(labels); i += 2 {
+				found := false
+				for _, l := range m.GetLabel() {
+					if l.GetName() == labels[i] && l.GetValue() == labels[i+1] {
+						found = true
+					}
+				}
+				if !found {
+					continue series
+				}
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPublishMetrics(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "metrics_ex", ExchangeTopic, "metrics_q", "#", nil)
+	published := func() float64 {
+		return metricValue(t, "peril_pubsub_messages_published_total", "exchange", "metrics_ex", "routing_key", "k")
+	}
+	failed := func() float64 {
+		return metricValue(t, "peril_pubsub_publish_errors_total", "exchange", "metrics_missing", "routing_key", "k")
+	}
+	publishedBefore, failedBefore := published(), failed()
+
+	if err := PublishJSON(b, "metrics_ex", "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(b, "metrics_missing", "k", 1); err == nil {
+		t.Fatal("published to an exchange that doesn't exist")
+	}
+	if got := published() - publishedBefore; got != 1 {
+		t.Errorf("messages_published_total went up by %v, want 1", got)
+	}
+	if got := failed() - failedBefore; got != 1 {
+		t.Errorf("publish_errors_total went up by %v, want 1", got)
+	}
+}
+
+func TestSubscribeMetrics(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("metrics_ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	settled := func(ack string) float64 {
+		return metricValue(t, "peril_pubsub_messages_handled_total", "queue", "metrics_sub", "ack", ack)
+	}
+	undecodable := func() float64 {
+		return metricValue(t, "peril_pubsub_decode_failures_total", "queue", "metrics_sub")
+	}
+	ackedBefore, discardedBefore, undecodableBefore := settled("ack"), settled("nack-discard"), undecodable()
+
+	handled := make(chan struct{}, 2)
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "metrics_ex", "metrics_sub", "#", Transient,
+		func(n int) SimpleAckType {
+			defer func() { handled <- struct{}{} }()
+			if n < 0 {
+				return NackDiscard
+			}
+			return Ack
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	// Deliveries are decoded in order, so the bad one is counted by the
+	// time the others are handled.
+	bad := Message{ContentType: JSON.ContentType(), Body: []byte("not json")}
+	if err := b.Publish(context.Background(), "metrics_ex", "k", bad); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1, -1} {
+		if err := PublishJSON(b, "metrics_ex", "k", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("messages weren't handled")
+		}
+	}
+	sub.Close()
+	sub.Wait()
+
+	if got := settled("ack") - ackedBefore; got != 1 {
+		t.Errorf("acked messages went up by %v, want 1", got)
+	}
+	if got := settled("nack-discard") - discardedBefore; got != 1 {
+		t.Errorf("discarded messages went up by %v, want 1", got)
+	}
+	if got := undecodable() - undecodableBefore; got != 1 {
+		t.Errorf("decode_failures_total went up by %v, want 1", got)
+	}
+	if n := metricValue(t, "peril_pubsub_handler_duration_seconds", "queue", "metrics_sub"); n < 2 {
+		t.Errorf("recorded %v handler durations, want at least 2", n)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	srv, err := ServeMetrics(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if _, err := ServeMetrics(addr); err == nil {
+		t.Fatal("ServeMetrics on an address in use succeeded")
+	}
+
+	if err := PublishJSON(NewMemoryServer().Connect(), "", "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"peril_pubsub_messages_published_total", "go_goroutines"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("/metrics doesn't report %s", name)
+		}
+	}
+}
+
+func TestRepublishMetrics(t *testing.T) {
+	published := func(exchange, key string) float64 {
+		return metricValue(t, "peril_pubsub_messages_published_total", "exchange", exchange, "routing_key", key)
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		b := NewMemoryServer().Connect()
+		defer b.Close()
+		if err := b.DeclareExchange("metrics_ex", ExchangeTopic, true); err != nil {
+			t.Fatal(err)
+		}
+		retryQueue := fmt.Sprintf("metrics_retry.retry.%dms", fastRetries.Delay(1).Milliseconds())
+		before := published("", retryQueue)
+		done := make(chan struct{})
+		var calls atomic.Int32
+		sub, err := SubscribeJSONWithContext(context.Background(), b, "metrics_ex", "metrics_retry", "#", Transient,
+			func(int) SimpleAckType {
+				if calls.Add(1) == 1 {
+					return RetryLater
+				}
+				close(done)
+				return Ack
+			},
+			WithRetry[int](fastRetries),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+		if err := PublishJSON(b, "metrics_ex", "k", 1); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the message was never retried")
+		}
+		if got := published("", retryQueue) - before; got != 1 {
+			t.Errorf("retry republishes went up by %v, want 1", got)
+		}
+	})
+
+	t.Run("rpc", func(t *testing.T) {
+		b := NewMemoryServer().Connect()
+		defer b.Close()
+		serveEcho(t, b)
+		// The fresh server names the first reply queue amq.gen-1.
+		requestsBefore, repliesBefore := published("rpc", "echo"), published("", "amq.gen-1")
+		if _, err := Call[echoRequest, echoResponse](context.Background(), b, JSON, "rpc", "echo", echoRequest{Text: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := published("rpc", "echo") - requestsBefore; got != 1 {
+			t.Errorf("calls went up by %v, want 1", got)
+		}
+		if got := published("", "amq.gen-1") - repliesBefore; got != 1 {
+			t.Errorf("replies went up by %v, want 1", got)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		b := NewMemoryServer().Connect()
+		defer b.Close()
+		declare(t, b, "metrics_ex", ExchangeTopic, "metrics_q", "#", nil)
+		before := published("metrics_ex", "replayed")
+		if err := PublishMessage(context.Background(), b, "metrics_ex", "replayed", Message{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := published("metrics_ex", "replayed") - before; got != 1 {
+			t.Errorf("messages_published_total went up by %v, want 1", got)
+		}
+	})
+}
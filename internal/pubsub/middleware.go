@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+type Handler[T any] func(Delivery[T]) SimpleAckType
+
+// Middleware wraps a handler. Middleware passed to WithMiddleware runs in
+// the order given, the first one being the outermost.
+type Middleware[T any] func(next Handler[T]) Handler[T]
+
+func WithMiddleware[T any](mw ...Middleware[T]) SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+func chain[T any](h Handler[T], middleware []Middleware[T]) Handler[T] {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Recover turns a panicking handler into a NackDiscard so one bad message
+// can't take down the consumer goroutine.
+func Recover[T any]() Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(d Delivery[T]) (ack SimpleAckType) {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Printf("handler panicked on message %s: %v\n%s", d.MessageID, r, debug.Stack())
+					ack = NackDiscard
+				}
+			}()
+			return next(d)
+		}
+	}
+}
+
+// Timeout settles the message with ack if the handler takes longer than
+// timeout. The handler can't be interrupted, so it keeps running in the
+// background.
+func Timeout[T any](timeout time.Duration, ack SimpleAckType) Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(d Delivery[T]) SimpleAckType {
+			result := make(chan SimpleAckType, 1)
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						panicked <- r
+					}
+				}()
+				result <- next(d)
+			}()
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case r := <-result:
+				return r
+			case r := <-panicked:
+				panic(r)
+			case <-timer.C:
+				return ack
+			}
+		}
+	}
+}
+
+// Logging records every handled message with its ack type and duration.
+func Logging[T any](logger *slog.Logger) Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(d Delivery[T]) SimpleAckType {
+			start := time.Now()
+			ack := next(d)
+			logger.Info("handled message",
+				"type", fmt.Sprintf("%T", d.Value),
+				"message_id", d.MessageID,
+				"routing_key", d.RoutingKey,
+				"app_id", d.AppID,
+				"ack", ack.String(),
+				"duration", time.Since(start),
+			)
+			return ack
+		}
+	}
+}
+
+// RedrawPrompt prints prompt after the handler, since handlers write over
+// the REPL's prompt line.
+func RedrawPrompt[T any](prompt string) Middleware[T] {
+	return func(next Handler[T]) Handler[T] {
+		return func(d Delivery[T]) SimpleAckType {
+			defer fmt.Print(prompt)
+			return next(d)
+		}
+	}
+}
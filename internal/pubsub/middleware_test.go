@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware[string] {
+		return func(next Handler[string]) Handler[string] {
+			return func(d Delivery[string]) SimpleAckType {
+				calls = append(calls, name)
+				return next(d)
+			}
+		}
+	}
+	h := chain(func(Delivery[string]) SimpleAckType {
+		calls = append(calls, "handler")
+		return Ack
+	}, []Middleware[string]{trace("outer"), trace("inner")})
+	h(Delivery[string]{})
+	if want := []string{"outer", "inner", "handler"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestWithMiddlewareAccumulates(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan string, 3)
+	trace := func(name string) Middleware[string] {
+		return func(next Handler[string]) Handler[string] {
+			return func(d Delivery[string]) SimpleAckType {
+				calls <- name
+				return next(d)
+			}
+		}
+	}
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType {
+			calls <- "handler"
+			return Ack
+		},
+		WithMiddleware(trace("first")),
+		WithMiddleware(trace("second")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := PublishJSON(b, "ex", "k", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first", "second", "handler"} {
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Fatalf("called %s, want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s wasn't called", want)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Recover[string]()(func(Delivery[string]) SimpleAckType { panic("boom") })
+	if got := h(Delivery[string]{}); got != NackDiscard {
+		t.Fatalf("Recover = %v, want NackDiscard", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := Timeout[string](10*time.Millisecond, NackRequeue)(func(Delivery[string]) SimpleAckType {
+		<-release
+		return Ack
+	})
+	if got := slow(Delivery[string]{}); got != NackRequeue {
+		t.Fatalf("slow handler = %v, want NackRequeue", got)
+	}
+
+	fast := Timeout[string](time.Second, NackRequeue)(func(Delivery[string]) SimpleAckType { return Ack })
+	if got := fast(Delivery[string]{}); got != Ack {
+		t.Fatalf("fast handler = %v, want Ack", got)
+	}
+}
+
+func TestTimeoutPassesPanicsToRecover(t *testing.T) {
+	h := Recover[string]()(Timeout[string](time.Second, Ack)(func(Delivery[string]) SimpleAckType {
+		panic("boom")
+	}))
+	if got := h(Delivery[string]{}); got != NackDiscard {
+		t.Fatalf("got %v, want NackDiscard", got)
+	}
+}
+
+func TestRecoverDeadLettersPanickingMessage(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, DeadLetterExchange, ExchangeFanout, "dead", "", nil)
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan struct{}, 2)
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(s string) SimpleAckType {
+			handled <- struct{}{}
+			if s == "bad" {
+				panic("boom")
+			}
+			return Ack
+		},
+		WithMiddleware(Recover[string]()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	for _, body := range []string{"bad", "good"} {
+		if err := PublishJSON(b, "ex", "k", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := waitForMessage(t, b, "dead")
+	if v, err := decode[string](d); err != nil || v != "bad" {
+		t.Fatalf("dead-lettered %q (%v), want \"bad\"", v, err)
+	}
+	for range 2 {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("the consumer stopped after a panic")
+		}
+	}
+}
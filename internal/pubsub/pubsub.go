@@ -1,13 +1,11 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"fmt"
+	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type SimpleQueueType int
@@ -20,151 +18,295 @@ const (
 type SimpleAckType int
 
 const (
-	Ack int = iota
+	Ack SimpleAckType = iota
 	NackRequeue
 	NackDiscard
+	// RetryLater redelivers the message after a backoff delay, up to the
+	// subscription's RetryPolicy.MaxAttempts, then dead-letters it.
+	RetryLater
 )
 
+func (a SimpleAckType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "nack-requeue"
+	case NackDiscard:
+		return "nack-discard"
+	case RetryLater:
+		return "retry-later"
+	default:
+		return fmt.Sprintf("SimpleAckType(%d)", int(a))
+	}
+}
+
+const DeadLetterExchange = "peril_dlx"
+
+const defaultPrefetch = 10
+
+type PublishOption func(*Message)
+
+// Mandatory makes the publish fail with ErrUnroutable when no queue is bound
+// to receive it. The broker must be dialed WithConfirms to find out.
+func Mandatory() PublishOption {
+	return func(msg *Message) {
+		msg.Mandatory = true
+	}
+}
+
 func DeclareAndBind(
-	conn *amqp.Connection,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	queueType SimpleQueueType,
-) (*amqp.Channel, amqp.Queue, error) {
-	var queue amqp.Queue
-	connectionChannel, err := conn.Channel()
-	if err != nil {
-		return nil, queue, err
-	}
-	queue, err = connectionChannel.QueueDeclare(
+) (Queue, error) {
+	queue, err := b.DeclareQueue(
 		queueName,
-		queueType == Durable,
-		queueType == Transient,
-		queueType == Transient,
-		false,
-		amqp.Table{
-			"x-dead-letter-exchange": "peril_dlx",
+		queueType,
+		Table{
+			"x-dead-letter-exchange": DeadLetterExchange,
 		},
 	)
 	if err != nil {
-		return nil, queue, err
+		return queue, err
 	}
-	if err = connectionChannel.QueueBind(queue.Name, key, exchange, false, nil); err != nil {
-		return nil, queue, err
+	if err = b.BindQueue(queue.Name, key, exchange); err != nil {
+		return queue, err
 	}
-	return connectionChannel, queue, nil
+	return queue, nil
 }
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	valJSON, err := json.Marshal(val)
+// Publish encodes val with codec and publishes it, tagging the message with
+// the codec's content type so subscribers know how to decode it.
+func Publish[T any](ctx context.Context, p Publisher, codec Codec, exchange, key string, val T, opts ...PublishOption) error {
+	body, err := codec.Marshal(val)
 	if err != nil {
 		return err
 	}
-	return ch.PublishWithContext(
-		context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        valJSON,
-		},
-	)
+	msg := Message{
+		ContentType: codec.ContentType(),
+		Body:        body,
+	}
+	envelope(&msg, val)
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	span := startPublishSpan(ctx, exchange, key, &msg)
+	err = PublishMessage(ctx, p, exchange, key, msg)
+	endSpan(span, err)
+	return err
+}
+
+// PublishMessage publishes msg as it is and counts it in Metrics. It starts
+// no span, so messages passed on unchanged, like retries and DLQ replays,
+// stay in the trace they were first published in.
+func PublishMessage(ctx context.Context, p Publisher, exchange, key string, msg Message) error {
+	err := p.Publish(ctx, exchange, key, msg)
+	countPublish(exchange, key, err)
+	return err
+}
+
+func countPublish(exchange, key string, err error) {
+	if err != nil {
+		publishErrors.WithLabelValues(exchange, key).Inc()
+		return
+	}
+	messagesPublished.WithLabelValues(exchange, key).Inc()
+}
+
+// Subscribe decodes each delivery with the codec registered for its content
+// type and hands it to handler along with its envelope. Messages with an
+// unknown content type or a body that fails to decode are rejected to the
+// dead-letter exchange.
+func Subscribe[T any](
+	ctx context.Context,
+	b Broker,
+	exchange, queueName, key string,
+	queueType SimpleQueueType,
+	handler Handler[T],
+	opts ...SubscribeOption[T],
+) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, queueType, handler, opts...)
+}
+
+func PublishJSON[T any](b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return PublishJSONWithContext(context.Background(), b, exchange, key, val, opts...)
+}
+
+func PublishJSONWithContext[T any](ctx context.Context, b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(ctx, b, JSON, exchange, key, val, opts...)
 }
 
 func SubscribeJSON[T any](
-	conn *amqp.Connection,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	queueType SimpleQueueType,
 	handler func(T) SimpleAckType,
+	opts ...SubscribeOption[T],
 ) error {
-	return subscribe(
-		conn,
-		exchange, queueName, key,
-		queueType,
-		handler,
-		func(data []byte, buf *T) error {
-			return json.Unmarshal(data, buf)
-		},
-	)
+	_, err := SubscribeJSONWithContext(context.Background(), b, exchange, queueName, key, queueType, handler, opts...)
+	return err
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(val); err != nil {
-		return err
-	}
-	return ch.PublishWithContext(
-		context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/gob",
-			Body:        buf.Bytes(),
-		},
-	)
+func SubscribeJSONWithContext[T any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	handler func(T) SimpleAckType,
+	opts ...SubscribeOption[T],
+) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, queueType, ValuesOnly(handler), opts...)
 }
 
-func SubscribeGob[T any] (
-	conn *amqp.Connection,
+func PublishGob[T any](b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return PublishGobWithContext(context.Background(), b, exchange, key, val, opts...)
+}
+
+func PublishGobWithContext[T any](ctx context.Context, b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(ctx, b, Gob, exchange, key, val, opts...)
+}
+
+func SubscribeGob[T any](
+	b Broker,
 	exchange, queueName, key string,
 	queueType SimpleQueueType,
 	handler func(T) SimpleAckType,
+	opts ...SubscribeOption[T],
 ) error {
-    return subscribe(conn,
-    exchange, queueName, key,
-    queueType, handler,
-    func (data []byte, buf *T) error  {
-        bytesBuf := bytes.NewBuffer(data)
-        dec := gob.NewDecoder(bytesBuf)
-        return dec.Decode(buf)
-    },
-)
+	_, err := SubscribeGobWithContext(context.Background(), b, exchange, queueName, key, queueType, handler, opts...)
+	return err
+}
 
+func SubscribeGobWithContext[T any](
+	ctx context.Context,
+	b Broker,
+	exchange, queueName, key string,
+	queueType SimpleQueueType,
+	handler func(T) SimpleAckType,
+	opts ...SubscribeOption[T],
+) (*Subscription, error) {
+	return subscribe(ctx, b, exchange, queueName, key, queueType, ValuesOnly(handler), opts...)
 }
 
+// ValuesOnly adapts a handler that doesn't need the envelope.
+func ValuesOnly[T any](handler func(T) SimpleAckType) Handler[T] {
+	return func(d Delivery[T]) SimpleAckType {
+		return handler(d.Value)
+	}
+}
+
+// subscribe consumes until ctx is cancelled or the returned Subscription is
+// closed. Either way the consumer is cancelled first and whatever the broker
+// had already delivered is still handled before the subscription finishes.
 func subscribe[T any](
-	conn *amqp.Connection,
+	ctx context.Context,
+	b Broker,
 	exchange, queueName, key string,
 	queueType SimpleQueueType,
-	handler func(T) SimpleAckType,
-	unmarshaller func([]byte, *T) error,
-) error {
-	queueChan, _, err := DeclareAndBind(conn, exchange, queueName, key, queueType)
+	handler Handler[T],
+	opts ...SubscribeOption[T],
+) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	h := chain(handler, options.middleware)
+	orderKey := orderKeyFunc(options)
+	queue, err := DeclareAndBind(b, exchange, queueName, key, queueType)
 	if err != nil {
-		return err
+		return nil, err
 	}
-    queueChan.Qos(10,0,true)
-	deliveryChan, err := queueChan.Consume("", "", false, false, false, false, nil)
+	retries := newRetrier(b, queue.Name, queueType, options.retry)
+	sub := newSubscription(b, queue.Name)
+	deliveryChan, err := b.Consume(queue.Name, sub.tag, max(defaultPrefetch, options.workers))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+		case <-sub.done:
+		}
+	}()
+
+	settle := func(j job[T]) {
+		ctx, span := startProcessSpan(queue.Name, j.delivery)
+		defer span.End()
+		j.value.ctx = ctx
+		id := j.value.MessageID
+		if options.dedup != nil && id != "" {
+			seen, err := options.dedup.Seen(id)
+			if err != nil {
+				fmt.Printf("error checking for duplicate message: %v\n", err)
+			}
+			if seen {
+				duplicatesSkipped.WithLabelValues(queue.Name).Inc()
+				j.delivery.Ack()
+				return
+			}
+		}
+		start := time.Now()
+		ack := h(j.value)
+		handlerDuration.WithLabelValues(queue.Name).Observe(time.Since(start).Seconds())
+		messagesHandled.WithLabelValues(queue.Name, j.delivery.Exchange, j.delivery.RoutingKey, ack.String()).Inc()
+		span.SetAttributes(attribute.String("messaging.rabbitmq.ack", ack.String()))
+		switch ack {
+		case Ack:
+			if options.dedup != nil && id != "" {
+				if err := options.dedup.Mark(id); err != nil {
+					fmt.Printf("error recording message %s: %v\n", id, err)
+				}
+			}
+			j.delivery.Ack()
+		case NackRequeue:
+			j.delivery.Nack(true)
+		case NackDiscard:
+			j.delivery.Nack(false)
+		case RetryLater:
+			if err := retries.retry(j.delivery); err != nil {
+				fmt.Printf("error scheduling retry: %v\n", err)
+			}
+		default:
+			// messagesHandled has already counted it under its ack label.
+			// Left unsettled, it would hold a prefetch slot for good.
+			fmt.Printf("handler returned unknown %v, discarding the message\n", ack)
+			j.delivery.Nack(false)
+		}
 	}
+	pool := newWorkerPool(options.workers, orderKey != nil, settle)
 	go func() {
+		defer close(sub.done)
+		defer pool.stop()
 		for d := range deliveryChan {
-			var v T
-			if err := unmarshaller(d.Body, &v); err != nil {
-				fmt.Printf("error unmarshalling data: %v", err)
-				d.Ack(false)
+			v, err := decode[T](d)
+			if err != nil {
+				fmt.Printf("error unmarshalling data: %v\n", err)
+				decodeFailures.WithLabelValues(queue.Name, d.ContentType).Inc()
+				d.Nack(false)
 				continue
 			}
-			ackType := handler(v)
-			switch ackType {
-			case SimpleAckType(Ack):
-				d.Ack(false)
-			case SimpleAckType(NackRequeue):
-				d.Nack(false, true)
-			case SimpleAckType(NackDiscard):
-				d.Nack(false, false)
-
+			j := job[T]{delivery: d, value: newDelivery(d, v)}
+			if orderKey != nil {
+				pool.submitKeyed(orderKey(j), j)
+			} else {
+				pool.submit(j)
 			}
 		}
 	}()
-	return nil
+	return sub, nil
+}
+
+func decode[T any](d RawDelivery) (T, error) {
+	var v T
+	codec, err := CodecFor(d.ContentType)
+	if err != nil {
+		return v, err
+	}
+	err = codec.Unmarshal(d.Body, &v)
+	return v, err
 }
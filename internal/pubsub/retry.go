@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	retryAttemptHeader     = "x-retry-attempt"
+	originalExchangeHeader = "x-original-exchange"
+	originalKeyHeader      = "x-original-routing-key"
+)
+
+// RetryPolicy controls what happens when a handler returns RetryLater. The
+// message is parked in a retry queue whose TTL is the backoff delay and which
+// dead-letters back into the subscription's queue when it expires. Once a
+// message has been handled MaxAttempts times it goes to the dead-letter
+// exchange instead.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+}
+
+// Delay is how long a message waits after its attempt-th failed attempt.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if delay >= float64(p.MaxDelay) {
+			return p.MaxDelay
+		}
+	}
+	return time.Duration(delay)
+}
+
+// Attempts returns how many times a message has already been retried.
+func Attempts(headers Table) int {
+	switch n := headers[retryAttemptHeader].(type) {
+	case int64:
+		return int(n)
+	case int32:
+		return int(n)
+	case int:
+		return n
+	default:
+		return 0
+	}
+}
+
+// OriginalRoute returns the exchange and routing key a message was first
+// published with, looking through any retry hops it has taken.
+func OriginalRoute(d RawDelivery) (exchange, key string) {
+	if ex, ok := d.Headers[originalExchangeHeader].(string); ok {
+		key, _ := d.Headers[originalKeyHeader].(string)
+		return ex, key
+	}
+	return d.Exchange, d.RoutingKey
+}
+
+type retrier struct {
+	broker    Broker
+	queue     string
+	queueType SimpleQueueType
+	policy    RetryPolicy
+
+	mu       sync.Mutex
+	declared map[time.Duration]string
+}
+
+func newRetrier(b Broker, queue string, queueType SimpleQueueType, policy RetryPolicy) *retrier {
+	return &retrier{
+		broker:    b,
+		queue:     queue,
+		queueType: queueType,
+		policy:    policy,
+		declared:  map[time.Duration]string{},
+	}
+}
+
+// retry settles d by scheduling another attempt, or by dead-lettering it
+// when it has run out of attempts. If the retry can't be scheduled the
+// message is requeued rather than lost.
+func (r *retrier) retry(d RawDelivery) error {
+	attempt := Attempts(d.Headers) + 1
+	if attempt >= r.policy.MaxAttempts {
+		return d.Nack(false)
+	}
+	retryQueue, err := r.retryQueue(r.policy.Delay(attempt))
+	if err != nil {
+		d.Nack(true)
+		return err
+	}
+	headers := copyTable(d.Headers)
+	if headers == nil {
+		headers = Table{}
+	}
+	headers[retryAttemptHeader] = int64(attempt)
+	if _, ok := headers[originalExchangeHeader]; !ok {
+		headers[originalExchangeHeader] = d.Exchange
+		headers[originalKeyHeader] = d.RoutingKey
+	}
+	msg := d.Message
+	msg.Headers = headers
+	msg.Mandatory = false
+	if err := PublishMessage(context.Background(), r.broker, "", retryQueue, msg); err != nil {
+		d.Nack(true)
+		return err
+	}
+	return d.Ack()
+}
+
+func (r *retrier) retryQueue(delay time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name, ok := r.declared[delay]; ok {
+		return name, nil
+	}
+	name := fmt.Sprintf("%s.retry.%dms", r.queue, delay.Milliseconds())
+	_, err := r.broker.DeclareQueue(name, r.queueType, Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": r.queue,
+	})
+	if err != nil {
+		return "", err
+	}
+	r.declared[delay] = name
+	return name, nil
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 300 * time.Millisecond},
+		{3, 900 * time.Millisecond},
+		{4, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.attempt); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+var fastRetries = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: 10 * time.Millisecond,
+	MaxDelay:     20 * time.Millisecond,
+	Multiplier:   2,
+}
+
+func TestRetryLaterDeadLettersAfterMaxAttempts(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, DeadLetterExchange, ExchangeFanout, "dead", "", nil)
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType {
+			calls.Add(1)
+			return RetryLater
+		},
+		WithRetry[string](fastRetries),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := PublishJSON(b, "ex", "moves.alice", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := waitForMessage(t, b, "dead")
+	if got := calls.Load(); got != int32(fastRetries.MaxAttempts) {
+		t.Errorf("handled %d times, want %d", got, fastRetries.MaxAttempts)
+	}
+	if got := Attempts(d.Headers); got != fastRetries.MaxAttempts-1 {
+		t.Errorf("Attempts = %d, want %d", got, fastRetries.MaxAttempts-1)
+	}
+	if ex, key := OriginalRoute(d); ex != "ex" || key != "moves.alice" {
+		t.Errorf("OriginalRoute = %s, %s", ex, key)
+	}
+}
+
+func TestRetryLaterThenAck(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	done := make(chan struct{})
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType {
+			if calls.Add(1) == 1 {
+				return RetryLater
+			}
+			close(done)
+			return Ack
+		},
+		WithRetry[string](fastRetries),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	start := time.Now()
+	if err := PublishJSON(b, "ex", "k", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the message was never retried")
+	}
+	if elapsed := time.Since(start); elapsed < fastRetries.InitialDelay {
+		t.Errorf("retried after %v, before the %v delay", elapsed, fastRetries.InitialDelay)
+	}
+}
@@ -0,0 +1,166 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	rpcErrorHeader     = "x-rpc-error"
+	defaultCallTimeout = 5 * time.Second
+)
+
+var (
+	ErrNoResponder = errors.New("pubsub: no server is serving this call")
+	ErrCallTimeout = errors.New("pubsub: call timed out waiting for a reply")
+)
+
+// RemoteError is returned by Call when the server's handler failed.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "pubsub: remote: " + e.Message
+}
+
+// ReplyPublisher is implemented by brokers that can route replies straight
+// back to the publishing channel, like RabbitMQ's direct reply-to. Call
+// falls back to a temporary reply queue on brokers that can't.
+type ReplyPublisher interface {
+	// PublishForReply publishes msg with ReplyTo set and returns the replies
+	// that come back to it until done is called.
+	PublishForReply(ctx context.Context, exchange, key string, msg Message) (replies <-chan RawDelivery, done func(), err error)
+}
+
+// Call publishes req encoded with codec and waits for the reply whose
+// correlation ID matches, which Serve encodes with the same codec. It fails
+// with ErrNoResponder when nothing is bound to key (the broker must be
+// dialed WithConfirms to tell), with ErrCallTimeout when ctx has no
+// deadline and no reply arrives within five seconds, and with a
+// *RemoteError when the server's handler returned an error.
+func Call[Req, Resp any](ctx context.Context, b Broker, codec Codec, exchange, key string, req Req, opts ...PublishOption) (resp Resp, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeout)
+		defer cancel()
+	}
+	body, err := codec.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	msg := Message{
+		ContentType: codec.ContentType(),
+		Body:        body,
+	}
+	envelope(&msg, req)
+	msg.CorrelationID = NewMessageID()
+	msg.Mandatory = true
+	for _, opt := range opts {
+		opt(&msg)
+	}
+
+	span := startPublishSpan(ctx, exchange, key, &msg)
+	defer func() { endSpan(span, err) }()
+	replies, done, err := publishForReply(ctx, b, exchange, key, msg)
+	countPublish(exchange, key, err)
+	if errors.Is(err, ErrUnroutable) {
+		return resp, fmt.Errorf("%w: %w", ErrNoResponder, err)
+	}
+	if err != nil {
+		return resp, err
+	}
+	defer done()
+	for {
+		select {
+		case <-ctx.Done():
+			return resp, fmt.Errorf("%w: %w", ErrCallTimeout, ctx.Err())
+		case d, ok := <-replies:
+			if !ok {
+				return resp, ErrClosed
+			}
+			d.Ack()
+			if d.CorrelationID != msg.CorrelationID {
+				// A late reply to an earlier call on a reused reply queue.
+				continue
+			}
+			if text, ok := d.Headers[rpcErrorHeader].(string); ok {
+				return resp, &RemoteError{Message: text}
+			}
+			err = codec.Unmarshal(d.Body, &resp)
+			return resp, err
+		}
+	}
+}
+
+func publishForReply(ctx context.Context, b Broker, exchange, key string, msg Message) (<-chan RawDelivery, func(), error) {
+	if rp, ok := b.(ReplyPublisher); ok {
+		return rp.PublishForReply(ctx, exchange, key, msg)
+	}
+	queue, err := b.DeclareQueue("", Transient, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	tag := newConsumerTag(queue.Name)
+	replies, err := b.Consume(queue.Name, tag, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := func() {
+		// The queue is transient, so it goes away with its only consumer.
+		b.Cancel(tag)
+		for d := range replies {
+			d.Nack(false)
+		}
+	}
+	msg.ReplyTo = queue.Name
+	if err := b.Publish(ctx, exchange, key, msg); err != nil {
+		done()
+		return nil, nil, err
+	}
+	return replies, done, nil
+}
+
+// Serve answers calls made with Call. Each reply goes to the request's
+// ReplyTo through the default exchange, carries its correlation ID and is
+// encoded with the request's codec. A handler error is sent back to the
+// caller as a RemoteError. Requests are always acked, since a caller that
+// has given up won't be waiting for a redelivered one.
+func Serve[Req, Resp any](
+	ctx context.Context,
+	b Broker,
+	exchange, queueName, key string,
+	queueType SimpleQueueType,
+	handler func(Delivery[Req]) (Resp, error),
+	opts ...SubscribeOption[Req],
+) (*Subscription, error) {
+	return Subscribe(ctx, b, exchange, queueName, key, queueType, func(d Delivery[Req]) SimpleAckType {
+		if d.ReplyTo == "" {
+			return Ack
+		}
+		codec, err := CodecFor(d.ContentType)
+		if err != nil {
+			codec = JSON
+		}
+		msg := Message{ContentType: codec.ContentType()}
+		resp, err := handler(d)
+		if err == nil {
+			msg.Body, err = codec.Marshal(resp)
+		}
+		envelope(&msg, resp)
+		if err != nil {
+			msg.Headers[rpcErrorHeader] = err.Error()
+			msg.Body = nil
+		}
+		msg.CorrelationID = d.CorrelationID
+		span := startPublishSpan(d.Context(), "", d.ReplyTo, &msg)
+		err = PublishMessage(ctx, b, "", d.ReplyTo, msg)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Printf("error replying to %s: %v\n", d.ReplyTo, err)
+		}
+		return Ack
+	}, opts...)
+}
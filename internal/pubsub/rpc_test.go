@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoRequest struct {
+	Text string
+}
+
+type echoResponse struct {
+	Text  string
+	AppID string
+}
+
+// serveEcho answers calls on rpc.echo by upper-casing the text, and fails
+// when the text is empty.
+func serveEcho(t *testing.T, b Broker) {
+	t.Helper()
+	if err := b.DeclareExchange("rpc", ExchangeDirect, true); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Serve(context.Background(), b, "rpc", "echo", "echo", Transient,
+		func(d Delivery[echoRequest]) (echoResponse, error) {
+			if d.Value.Text == "" {
+				return echoResponse{}, errors.New("nothing to echo")
+			}
+			return echoResponse{Text: strings.ToUpper(d.Value.Text), AppID: d.AppID}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Close() })
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	serveEcho(t, b)
+
+	for _, codec := range []Codec{JSON, Gob, JSON} {
+		resp, err := Call[echoRequest, echoResponse](context.Background(), b, codec, "rpc", "echo", echoRequest{Text: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != "HELLO" || resp.AppID != AppID {
+			t.Fatalf("Call with %s = %+v", codec.ContentType(), resp)
+		}
+	}
+}
+
+func TestCallRemoteError(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	serveEcho(t, b)
+
+	_, err := Call[echoRequest, echoResponse](context.Background(), b, JSON, "rpc", "echo", echoRequest{})
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Message != "nothing to echo" {
+		t.Fatalf("Call = %v, want a RemoteError", err)
+	}
+}
+
+func TestCallNoResponder(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("rpc", ExchangeDirect, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Call[echoRequest, echoResponse](context.Background(), b, JSON, "rpc", "echo", echoRequest{Text: "hello"})
+	if !errors.Is(err, ErrNoResponder) || !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("Call = %v, want ErrNoResponder", err)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	// A queue that nobody answers from.
+	declare(t, b, "rpc", ExchangeDirect, "echo", "echo", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := Call[echoRequest, echoResponse](ctx, b, JSON, "rpc", "echo", echoRequest{Text: "hello"})
+	if !errors.Is(err, ErrCallTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call = %v, want ErrCallTimeout", err)
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var consumerSeq atomic.Uint64
+
+func newConsumerTag(queueName string) string {
+	return fmt.Sprintf("%s.%d.%d", queueName, os.Getpid(), consumerSeq.Add(1))
+}
+
+// Subscription is a running consumer started by one of the Subscribe
+// functions.
+type Subscription struct {
+	broker Broker
+	tag    string
+	done   chan struct{}
+
+	once sync.Once
+	err  error
+}
+
+func newSubscription(b Broker, queueName string) *Subscription {
+	return &Subscription{
+		broker: b,
+		tag:    newConsumerTag(queueName),
+		done:   make(chan struct{}),
+	}
+}
+
+// Close cancels the consumer and waits for the deliveries it already
+// received to be handled and settled.
+func (s *Subscription) Close() error {
+	s.once.Do(func() {
+		s.err = s.broker.Cancel(s.tag)
+	})
+	<-s.done
+	return s.err
+}
+
+// Wait blocks until the subscription has stopped, either through Close, its
+// context being cancelled or the broker shutting down.
+func (s *Subscription) Wait() {
+	<-s.done
+}
+
+func (s *Subscription) ConsumerTag() string {
+	return s.tag
+}
+
+type subscribeOptions[T any] struct {
+	retry      RetryPolicy
+	middleware []Middleware[T]
+	workers    int
+	rawKey     func(RawDelivery) string
+	valueKey   func(T) string
+	dedup      DedupStore
+}
+
+// SubscribeOption configures a subscription to messages of type T, so
+// middleware and order keys for another type don't compile. Options that
+// work for any type need it spelled out, as in WithWorkers[GameLog](10).
+type SubscribeOption[T any] func(*subscribeOptions[T])
+
+// WithRetry sets the policy used when the handler returns RetryLater.
+// Subscriptions use DefaultRetryPolicy otherwise.
+func WithRetry[T any](policy RetryPolicy) SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.retry = policy
+	}
+}
+
+func newSubscribeOptions[T any](opts []SubscribeOption[T]) subscribeOptions[T] {
+	o := subscribeOptions[T]{retry: DefaultRetryPolicy, workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
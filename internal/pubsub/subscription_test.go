@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionStopsWithContext(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := SubscribeJSONWithContext(ctx, b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType { return Ack })
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		sub.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("cancelling the context didn't stop the subscription")
+	}
+}
+
+func TestSubscriptionCloseWaitsForHandlers(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var finished atomic.Bool
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType {
+			close(started)
+			<-release
+			finished.Store(true)
+			return Ack
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(b, "ex", "k", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	closed := make(chan error, 1)
+	go func() { closed <- sub.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a handler was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Fatal("Close returned before the handler finished")
+	}
+}
+
+func TestSubscriptionCloseAfterBrokerClose(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Subscribe(context.Background(), b, "ex", "q", "#", Transient,
+		ValuesOnly(func(string) SimpleAckType { return Ack }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- sub.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close after the broker closed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close after the broker closed didn't return")
+	}
+}
+
+func TestSubscribeDiscardsUnknownAck(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, DeadLetterExchange, ExchangeFanout, "dead", "", nil)
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(string) SimpleAckType { return SimpleAckType(42) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := PublishJSON(b, "ex", "k", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, ok, err := b.Get("dead")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the message was never settled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,385 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Topology lists the exchanges, queues and bindings a set of processes
+// relies on. Applying it declares everything in order, which is a no-op for
+// whatever the broker already has with the same settings.
+type Topology struct {
+	Exchanges []ExchangeSpec `json:"exchanges,omitempty" yaml:"exchanges,omitempty"`
+	Queues    []QueueSpec    `json:"queues,omitempty" yaml:"queues,omitempty"`
+	Bindings  []BindingSpec  `json:"bindings,omitempty" yaml:"bindings,omitempty"`
+}
+
+type ExchangeSpec struct {
+	Name    string `json:"name" yaml:"name"`
+	Kind    string `json:"kind" yaml:"kind"`
+	Durable bool   `json:"durable" yaml:"durable"`
+}
+
+type QueueSpec struct {
+	Name      string `json:"name" yaml:"name"`
+	Durable   bool   `json:"durable" yaml:"durable"`
+	Arguments Table  `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+}
+
+type BindingSpec struct {
+	Exchange string `json:"exchange" yaml:"exchange"`
+	Queue    string `json:"queue" yaml:"queue"`
+	Key      string `json:"key" yaml:"key"`
+}
+
+// LoadTopology reads a topology from a YAML (.yaml, .yml) or JSON file.
+func LoadTopology(path string) (Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Topology{}, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseTopologyYAML(data)
+	default:
+		return ParseTopologyJSON(data)
+	}
+}
+
+func ParseTopologyJSON(data []byte) (Topology, error) {
+	var t Topology
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return Topology{}, fmt.Errorf("pubsub: parsing topology: %w", err)
+	}
+	return t.normalize()
+}
+
+func ParseTopologyYAML(data []byte) (Topology, error) {
+	var t Topology
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&t); err != nil {
+		return Topology{}, fmt.Errorf("pubsub: parsing topology: %w", err)
+	}
+	return t.normalize()
+}
+
+// normalize checks the topology and converts argument numbers to int64, the
+// type both brokers expect for things like x-message-ttl.
+func (t Topology) normalize() (Topology, error) {
+	for _, ex := range t.Exchanges {
+		switch ex.Kind {
+		case ExchangeDirect, ExchangeTopic, ExchangeFanout:
+		default:
+			return Topology{}, fmt.Errorf("pubsub: exchange %q has unknown kind %q", ex.Name, ex.Kind)
+		}
+	}
+	for i, q := range t.Queues {
+		if q.Name == "" {
+			return Topology{}, fmt.Errorf("pubsub: queue %d has no name", i)
+		}
+		args, err := normalizeArgs(q.Arguments)
+		if err != nil {
+			return Topology{}, fmt.Errorf("pubsub: queue %q: %w", q.Name, err)
+		}
+		t.Queues[i].Arguments = args
+	}
+	return t, nil
+}
+
+func normalizeArgs(t Table) (Table, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	out := make(Table, len(t))
+	for k, v := range t {
+		switch v := v.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %w", k, err)
+			}
+			out[k] = n
+		case int:
+			out[k] = int64(v)
+		case int32:
+			out[k] = int64(v)
+		case float64:
+			if v != float64(int64(v)) {
+				return nil, fmt.Errorf("argument %s: %v is not an integer", k, v)
+			}
+			out[k] = int64(v)
+		default:
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// Apply declares the topology on b. Exchanges come first, then queues, then
+// the bindings between them.
+func (t Topology) Apply(b Broker) error {
+	for _, ex := range t.Exchanges {
+		if err := b.DeclareExchange(ex.Name, ex.Kind, ex.Durable); err != nil {
+			return fmt.Errorf("declaring exchange %s: %w", ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		queueType := Transient
+		if q.Durable {
+			queueType = Durable
+		}
+		if _, err := b.DeclareQueue(q.Name, queueType, q.Arguments); err != nil {
+			return fmt.Errorf("declaring queue %s: %w", q.Name, err)
+		}
+	}
+	for _, bind := range t.Bindings {
+		if err := b.BindQueue(bind.Queue, bind.Key, bind.Exchange); err != nil {
+			return fmt.Errorf("binding %s to %s with %q: %w", bind.Queue, bind.Exchange, bind.Key, err)
+		}
+	}
+	return nil
+}
+
+// TopologyInspector is implemented by brokers that can report what they have
+// declared. For RabbitMQ, fetch the definitions from the management API.
+type TopologyInspector interface {
+	InspectTopology() (Topology, error)
+}
+
+type TopologyChange struct {
+	// Op is "missing" for things the broker lacks, "changed" for things it
+	// has with different settings and "extra" for things the topology
+	// doesn't mention.
+	Op     string
+	Kind   string
+	Name   string
+	Detail string
+}
+
+func (c TopologyChange) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Op, c.Kind, c.Name)
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// DiffTopology compares the topology a program wants with what a broker
+// has. The broker's built-in amq.* exchanges and server-named queues are
+// never reported as extra.
+func DiffTopology(want, have Topology) []TopologyChange {
+	var changes []TopologyChange
+
+	haveExchanges := map[string]ExchangeSpec{}
+	for _, ex := range have.Exchanges {
+		haveExchanges[ex.Name] = ex
+	}
+	wantExchanges := map[string]bool{}
+	for _, ex := range want.Exchanges {
+		wantExchanges[ex.Name] = true
+		got, ok := haveExchanges[ex.Name]
+		switch {
+		case !ok:
+			changes = append(changes, TopologyChange{Op: "missing", Kind: "exchange", Name: ex.Name})
+		case got.Kind != ex.Kind || got.Durable != ex.Durable:
+			changes = append(changes, TopologyChange{
+				Op:     "changed",
+				Kind:   "exchange",
+				Name:   ex.Name,
+				Detail: fmt.Sprintf("want %s durable=%t, have %s durable=%t", ex.Kind, ex.Durable, got.Kind, got.Durable),
+			})
+		}
+	}
+	for _, ex := range have.Exchanges {
+		if !wantExchanges[ex.Name] && ex.Name != "" && !strings.HasPrefix(ex.Name, "amq.") {
+			changes = append(changes, TopologyChange{Op: "extra", Kind: "exchange", Name: ex.Name})
+		}
+	}
+
+	haveQueues := map[string]QueueSpec{}
+	for _, q := range have.Queues {
+		haveQueues[q.Name] = q
+	}
+	wantQueues := map[string]bool{}
+	for _, q := range want.Queues {
+		wantQueues[q.Name] = true
+		got, ok := haveQueues[q.Name]
+		switch {
+		case !ok:
+			changes = append(changes, TopologyChange{Op: "missing", Kind: "queue", Name: q.Name})
+		case got.Durable != q.Durable:
+			changes = append(changes, TopologyChange{
+				Op:     "changed",
+				Kind:   "queue",
+				Name:   q.Name,
+				Detail: fmt.Sprintf("want durable=%t, have durable=%t", q.Durable, got.Durable),
+			})
+		case !sameArgs(got.Arguments, q.Arguments):
+			changes = append(changes, TopologyChange{
+				Op:     "changed",
+				Kind:   "queue",
+				Name:   q.Name,
+				Detail: fmt.Sprintf("want arguments %v, have %v", q.Arguments, got.Arguments),
+			})
+		}
+	}
+	for _, q := range have.Queues {
+		if !wantQueues[q.Name] && !strings.HasPrefix(q.Name, "amq.") {
+			changes = append(changes, TopologyChange{Op: "extra", Kind: "queue", Name: q.Name})
+		}
+	}
+
+	haveBindings := map[BindingSpec]bool{}
+	for _, bind := range have.Bindings {
+		haveBindings[bind] = true
+	}
+	wantBindings := map[BindingSpec]bool{}
+	for _, bind := range want.Bindings {
+		wantBindings[bind] = true
+		if !haveBindings[bind] {
+			changes = append(changes, TopologyChange{Op: "missing", Kind: "binding", Name: bind.String()})
+		}
+	}
+	for _, bind := range have.Bindings {
+		if !wantBindings[bind] && wantQueues[bind.Queue] {
+			changes = append(changes, TopologyChange{Op: "extra", Kind: "binding", Name: bind.String()})
+		}
+	}
+	return changes
+}
+
+func (b BindingSpec) String() string {
+	return fmt.Sprintf("%s -> %s (%q)", b.Exchange, b.Queue, b.Key)
+}
+
+func sameArgs(a, b Table) bool {
+	na, err := normalizeArgs(a)
+	if err != nil {
+		return false
+	}
+	nb, err := normalizeArgs(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(na, nb)
+}
+
+// Definitions is RabbitMQ's definitions.json format, as read and written
+// by the management plugin and rabbitmqctl import_definitions.
+type Definitions struct {
+	Exchanges []DefinitionExchange `json:"exchanges"`
+	Queues    []DefinitionQueue    `json:"queues"`
+	Bindings  []DefinitionBinding  `json:"bindings"`
+}
+
+type DefinitionExchange struct {
+	Name       string `json:"name"`
+	Vhost      string `json:"vhost"`
+	Type       string `json:"type"`
+	Durable    bool   `json:"durable"`
+	AutoDelete bool   `json:"auto_delete"`
+	Internal   bool   `json:"internal"`
+	Arguments  Table  `json:"arguments"`
+}
+
+type DefinitionQueue struct {
+	Name       string `json:"name"`
+	Vhost      string `json:"vhost"`
+	Durable    bool   `json:"durable"`
+	AutoDelete bool   `json:"auto_delete"`
+	Arguments  Table  `json:"arguments"`
+}
+
+type DefinitionBinding struct {
+	Source          string `json:"source"`
+	Vhost           string `json:"vhost"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"`
+	RoutingKey      string `json:"routing_key"`
+	Arguments       Table  `json:"arguments"`
+}
+
+// Definitions exports the topology for vhost with the same flags Apply
+// declares it with. Transient queues become auto-delete queues, which is
+// the closest definitions.json gets to the exclusive queues Transient
+// declares; exchanges are never auto-delete.
+func (t Topology) Definitions(vhost string) Definitions {
+	d := Definitions{
+		Exchanges: []DefinitionExchange{},
+		Queues:    []DefinitionQueue{},
+		Bindings:  []DefinitionBinding{},
+	}
+	for _, ex := range t.Exchanges {
+		d.Exchanges = append(d.Exchanges, DefinitionExchange{
+			Name:      ex.Name,
+			Vhost:     vhost,
+			Type:      ex.Kind,
+			Durable:   ex.Durable,
+			Arguments: Table{},
+		})
+	}
+	for _, q := range t.Queues {
+		args := q.Arguments
+		if args == nil {
+			args = Table{}
+		}
+		d.Queues = append(d.Queues, DefinitionQueue{
+			Name:       q.Name,
+			Vhost:      vhost,
+			Durable:    q.Durable,
+			AutoDelete: !q.Durable,
+			Arguments:  args,
+		})
+	}
+	for _, bind := range t.Bindings {
+		d.Bindings = append(d.Bindings, DefinitionBinding{
+			Source:          bind.Exchange,
+			Vhost:           vhost,
+			Destination:     bind.Queue,
+			DestinationType: "queue",
+			RoutingKey:      bind.Key,
+			Arguments:       Table{},
+		})
+	}
+	return d
+}
+
+// Topology picks out what belongs to vhost. Bindings to exchanges are
+// skipped since Topology only binds queues.
+func (d Definitions) Topology(vhost string) (Topology, error) {
+	var t Topology
+	for _, ex := range d.Exchanges {
+		if ex.Vhost == vhost {
+			t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: ex.Name, Kind: ex.Type, Durable: ex.Durable})
+		}
+	}
+	for _, q := range d.Queues {
+		if q.Vhost == vhost {
+			t.Queues = append(t.Queues, QueueSpec{Name: q.Name, Durable: q.Durable, Arguments: q.Arguments})
+		}
+	}
+	for _, bind := range d.Bindings {
+		if bind.Vhost == vhost && bind.DestinationType == "queue" && bind.Source != "" {
+			t.Bindings = append(t.Bindings, BindingSpec{Exchange: bind.Source, Queue: bind.Destination, Key: bind.RoutingKey})
+		}
+	}
+	for i, q := range t.Queues {
+		args, err := normalizeArgs(q.Arguments)
+		if err != nil {
+			return Topology{}, fmt.Errorf("pubsub: queue %q: %w", q.Name, err)
+		}
+		t.Queues[i].Arguments = args
+	}
+	return t, nil
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testTopology = Topology{
+	Exchanges: []ExchangeSpec{
+		{Name: "durable", Kind: ExchangeTopic, Durable: true},
+		{Name: "transient", Kind: ExchangeDirect},
+	},
+	Queues: []QueueSpec{
+		{Name: "kept", Durable: true, Arguments: Table{"x-message-ttl": int64(1000)}},
+		{Name: "temp"},
+	},
+	Bindings: []BindingSpec{
+		{Exchange: "durable", Queue: "kept", Key: "#"},
+		{Exchange: "transient", Queue: "temp", Key: "temp"},
+	},
+}
+
+func TestDefinitionsMatchApply(t *testing.T) {
+	d := testTopology.Definitions("/")
+	for _, ex := range d.Exchanges {
+		if ex.AutoDelete {
+			t.Errorf("exchange %s is exported auto-delete, but Apply never declares one", ex.Name)
+		}
+	}
+	for _, q := range d.Queues {
+		if q.AutoDelete == q.Durable {
+			t.Errorf("queue %s: durable=%t auto_delete=%t, want auto-delete only when transient", q.Name, q.Durable, q.AutoDelete)
+		}
+	}
+
+	// Importing the export should give back the same topology.
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported Definitions
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	got, err := imported.Topology("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := DiffTopology(testTopology, got); len(changes) > 0 {
+		t.Errorf("exported definitions differ: %v", changes)
+	}
+	if other, _ := imported.Topology("other"); len(other.Exchanges) > 0 {
+		t.Errorf("vhost other has %v", other.Exchanges)
+	}
+}
+
+func TestApplyThenDiff(t *testing.T) {
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := testTopology.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	have, err := b.InspectTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := DiffTopology(testTopology, have); len(changes) > 0 {
+		t.Errorf("after Apply: %v", changes)
+	}
+}
+
+func TestDiffTopology(t *testing.T) {
+	tests := []struct {
+		name   string
+		have   func(*Topology)
+		op     string
+		kind   string
+		target string
+	}{
+		{
+			name:   "missing exchange",
+			have:   func(t *Topology) { t.Exchanges = t.Exchanges[1:] },
+			op:     "missing",
+			kind:   "exchange",
+			target: "durable",
+		},
+		{
+			name:   "changed exchange",
+			have:   func(t *Topology) { t.Exchanges[0].Kind = ExchangeFanout },
+			op:     "changed",
+			kind:   "exchange",
+			target: "durable",
+		},
+		{
+			name:   "extra exchange",
+			have:   func(t *Topology) { t.Exchanges = append(t.Exchanges, ExchangeSpec{Name: "stale", Kind: ExchangeTopic}) },
+			op:     "extra",
+			kind:   "exchange",
+			target: "stale",
+		},
+		{
+			name:   "changed queue arguments",
+			have:   func(t *Topology) { t.Queues[0].Arguments = Table{"x-message-ttl": int64(5)} },
+			op:     "changed",
+			kind:   "queue",
+			target: "kept",
+		},
+		{
+			name:   "missing binding",
+			have:   func(t *Topology) { t.Bindings = t.Bindings[:1] },
+			op:     "missing",
+			kind:   "binding",
+			target: BindingSpec{Exchange: "transient", Queue: "temp", Key: "temp"}.String(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			have := Topology{
+				Exchanges: append([]ExchangeSpec(nil), testTopology.Exchanges...),
+				Queues:    append([]QueueSpec(nil), testTopology.Queues...),
+				Bindings:  append([]BindingSpec(nil), testTopology.Bindings...),
+			}
+			// Built-in exchanges and server-named queues are never extra.
+			have.Exchanges = append(have.Exchanges, ExchangeSpec{Name: "amq.topic", Kind: ExchangeTopic, Durable: true})
+			have.Queues = append(have.Queues, QueueSpec{Name: "amq.gen-1"})
+			tt.have(&have)
+			changes := DiffTopology(testTopology, have)
+			if len(changes) != 1 {
+				t.Fatalf("got %v, want one change", changes)
+			}
+			c := changes[0]
+			if c.Op != tt.op || c.Kind != tt.kind || c.Name != tt.target {
+				t.Fatalf("got %v, want %s %s %s", c, tt.op, tt.kind, tt.target)
+			}
+		})
+	}
+}
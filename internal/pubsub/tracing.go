@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+
+// Trace context travels in the traceparent and tracestate headers, as W3C
+// Trace Context specifies. Spans go to the global tracer provider, which
+// drops them unless TraceToFile or the program has set one up.
+var propagator = propagation.TraceContext{}
+
+// headerCarrier lets the propagator read and write message headers.
+type headerCarrier Table
+
+func (c headerCarrier) Get(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startPublishSpan starts a producer span for msg and injects its context
+// into msg's headers.
+func startPublishSpan(ctx context.Context, exchange, key string, msg *Message) trace.Span {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "publish "+exchange,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "publish"),
+			attribute.String("messaging.destination.name", exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", key),
+			attribute.String("messaging.message.id", msg.MessageID),
+		),
+	)
+	if msg.Headers == nil {
+		msg.Headers = Table{}
+	}
+	propagator.Inject(ctx, headerCarrier(msg.Headers))
+	return span
+}
+
+// startProcessSpan continues the trace a delivery was published in with a
+// consumer span for handling it.
+func startProcessSpan(queue string, d RawDelivery) (context.Context, trace.Span) {
+	ctx := propagator.Extract(context.Background(), headerCarrier(d.Headers))
+	return otel.Tracer(tracerName).Start(ctx, "process "+queue,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.operation.type", "process"),
+			attribute.String("messaging.destination.name", d.Exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", d.RoutingKey),
+			attribute.String("messaging.message.id", d.MessageID),
+			attribute.String("messaging.consumer.group.name", queue),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceToFile installs a global tracer provider that writes finished spans
+// to path as JSON, one span per line, under AppID as the service name. Call
+// the returned function before exiting to flush the last spans.
+func TraceToFile(path string) (shutdown func(context.Context) error, err error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", AppID))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("pubsub: flushing traces: %w", err)
+		}
+		return nil
+	}, nil
+}
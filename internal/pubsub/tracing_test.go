@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sends spans to a recorder for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return sr
+}
+
+func TestTraceContextPropagates(t *testing.T) {
+	sr := recordSpans(t)
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan trace.SpanContext, 1)
+	sub, err := Subscribe(context.Background(), b, "ex", "q", "#", Transient,
+		func(d Delivery[string]) SimpleAckType {
+			got <- trace.SpanContextFromContext(d.Context())
+			return Ack
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "turn")
+	if err := Publish(ctx, b, JSON, "ex", "moves.alice", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	var consumer trace.SpanContext
+	select {
+	case consumer = <-got:
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+	if consumer.TraceID() != parent.SpanContext().TraceID() {
+		t.Fatalf("handler ran in trace %s, want %s", consumer.TraceID(), parent.SpanContext().TraceID())
+	}
+	sub.Close()
+	sub.Wait()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	publish, process := spans["publish ex"], spans["process q"]
+	if publish == nil || process == nil {
+		t.Fatalf("recorded spans %v", spans)
+	}
+	if publish.SpanKind() != trace.SpanKindProducer || publish.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("publish span is %v with parent %v", publish.SpanKind(), publish.Parent().SpanID())
+	}
+	if process.SpanKind() != trace.SpanKindConsumer || process.Parent().SpanID() != publish.SpanContext().SpanID() {
+		t.Errorf("process span is %v with parent %v", process.SpanKind(), process.Parent().SpanID())
+	}
+}
+
+func TestPublishSpanRecordsError(t *testing.T) {
+	sr := recordSpans(t)
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := PublishJSON(b, "missing", "k", 1); err == nil {
+		t.Fatal("published to an exchange that doesn't exist")
+	}
+	spans := sr.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error || len(spans[0].Events()) == 0 {
+		t.Fatalf("spans = %v", spans)
+	}
+}
+
+func TestCallSpanRecordsError(t *testing.T) {
+	sr := recordSpans(t)
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("rpc", ExchangeDirect, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Call[echoRequest, echoResponse](context.Background(), b, JSON, "rpc", "echo", echoRequest{}); !errors.Is(err, ErrNoResponder) {
+		t.Fatalf("Call = %v, want ErrNoResponder", err)
+	}
+	spans := sr.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error || len(spans[0].Events()) == 0 {
+		t.Fatalf("spans = %v", spans)
+	}
+}
+
+func TestTraceToFile(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := TraceToFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	declare(t, b, "ex", ExchangeTopic, "q", "#", nil)
+	if err := PublishJSON(b, "ex", "k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Name":"publish ex"`) {
+		t.Fatalf("trace file doesn't have the publish span:\n%s", data)
+	}
+}
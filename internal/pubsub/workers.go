@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// WithWorkers handles up to n messages at once. The prefetch is raised to n
+// if it is lower so every worker has something to do.
+func WithWorkers[T any](n int) SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.workers = max(n, 1)
+	}
+}
+
+// OrderedByRoutingKey keeps messages with the same routing key on the same
+// worker, so they are handled in the order they arrived while messages for
+// other keys run in parallel. Retried messages go by their original key.
+func OrderedByRoutingKey[T any]() SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.rawKey = func(d RawDelivery) string {
+			_, key := OriginalRoute(d)
+			return key
+		}
+		o.valueKey = nil
+	}
+}
+
+// OrderedBy is OrderedByRoutingKey for a key taken from the decoded message,
+// e.g. the username a game log belongs to.
+func OrderedBy[T any](key func(T) string) SubscribeOption[T] {
+	return func(o *subscribeOptions[T]) {
+		o.valueKey = key
+		o.rawKey = nil
+	}
+}
+
+type job[T any] struct {
+	delivery RawDelivery
+	value    Delivery[T]
+}
+
+func orderKeyFunc[T any](o subscribeOptions[T]) func(job[T]) string {
+	switch {
+	case o.rawKey != nil:
+		return func(j job[T]) string { return o.rawKey(j.delivery) }
+	case o.valueKey != nil:
+		return func(j job[T]) string { return o.valueKey(j.value.Value) }
+	default:
+		return nil
+	}
+}
+
+// workerPool runs handle on n goroutines. Unkeyed jobs go to whichever
+// worker is free; keyed jobs always go to the worker their key hashes to,
+// with a little buffering so one busy key doesn't hold up the others.
+type workerPool[T any] struct {
+	queues []chan job[T]
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool[T any](n int, keyed bool, handle func(job[T])) *workerPool[T] {
+	p := &workerPool[T]{}
+	if keyed {
+		p.queues = make([]chan job[T], n)
+		for i := range p.queues {
+			p.queues[i] = make(chan job[T], defaultPrefetch)
+		}
+	} else {
+		shared := make(chan job[T])
+		p.queues = []chan job[T]{shared}
+	}
+	for i := 0; i < n; i++ {
+		queue := p.queues[i%len(p.queues)]
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for j := range queue {
+				handle(j)
+			}
+		}()
+	}
+	return p
+}
+
+func (p *workerPool[T]) submit(j job[T]) {
+	p.queues[0] <- j
+}
+
+func (p *workerPool[T]) submitKeyed(key string, j job[T]) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- j
+}
+
+// stop waits for queued jobs to finish.
+func (p *workerPool[T]) stop() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
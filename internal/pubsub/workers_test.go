@@ -0,0 +1,114 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithWorkersRunsConcurrently(t *testing.T) {
+	const workers = 3
+	b := NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{}, workers)
+	release := make(chan struct{})
+	sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+		func(int) SimpleAckType {
+			started <- struct{}{}
+			<-release
+			return Ack
+		},
+		WithWorkers[int](workers),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	defer close(release)
+	for i := 0; i < workers; i++ {
+		if err := PublishJSON(b, "ex", "k", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every handler blocks until released, so they only all start if they
+	// run at once.
+	for i := 0; i < workers; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d handlers running at once", i, workers)
+		}
+	}
+}
+
+type orderedMessage struct {
+	Player string
+	Seq    int
+}
+
+func TestOrderedWorkers(t *testing.T) {
+	tests := []struct {
+		name  string
+		order SubscribeOption[orderedMessage]
+	}{
+		{"routing key", OrderedByRoutingKey[orderedMessage]()},
+		{"value", OrderedBy(func(m orderedMessage) string { return m.Player })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const perPlayer = 20
+			players := []string{"alice", "bob", "carol", "dave"}
+			b := NewMemoryServer().Connect()
+			defer b.Close()
+			if err := b.DeclareExchange("ex", ExchangeTopic, true); err != nil {
+				t.Fatal(err)
+			}
+			var mu sync.Mutex
+			got := map[string][]int{}
+			var wg sync.WaitGroup
+			wg.Add(perPlayer * len(players))
+			sub, err := SubscribeJSONWithContext(context.Background(), b, "ex", "q", "#", Transient,
+				func(m orderedMessage) SimpleAckType {
+					defer wg.Done()
+					time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+					mu.Lock()
+					got[m.Player] = append(got[m.Player], m.Seq)
+					mu.Unlock()
+					return Ack
+				},
+				WithWorkers[orderedMessage](4),
+				tt.order,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+			for seq := 0; seq < perPlayer; seq++ {
+				for _, p := range players {
+					m := orderedMessage{Player: p, Seq: seq}
+					if err := PublishJSON(b, "ex", fmt.Sprintf("moves.%s", p), m); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			wg.Wait()
+
+			want := make([]int, perPlayer)
+			for i := range want {
+				want[i] = i
+			}
+			for _, p := range players {
+				if !reflect.DeepEqual(got[p], want) {
+					t.Errorf("%s's messages were handled in order %v", p, got[p])
+				}
+			}
+		})
+	}
+}
@@ -1,13 +1,12 @@
 package routing
 
-import "time"
+import "github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 
-type PlayingState struct {
-	IsPaused bool
-}
+// The routes need every payload type, so the game's own live in gamelogic
+// and are aliased here.
+type (
+	PlayingState = gamelogic.PlayingState
+	GameLog      = gamelogic.GameLog
+)
 
-type GameLog struct {
-	CurrentTime time.Time
-	Message     string
-	Username    string
-}
+type PlayingStateRequest struct{}
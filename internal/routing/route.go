@@ -0,0 +1,218 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// Route ties an exchange and a routing key template to the payload type and
+// codec published on it. Templates are dot-separated words where a word in
+// braces, like {username}, is a parameter filled in when publishing and
+// matched by * when subscribing. Only routes on topic exchanges can have
+// parameters, since other kinds of exchange don't match wildcards.
+type Route[T any] struct {
+	Exchange string
+	// Kind is the exchange's kind, like pubsub.ExchangeTopic.
+	Kind  string
+	Key   string
+	Codec pubsub.Codec
+}
+
+type Params map[string]string
+
+// RoutingKey fills in the template. Every parameter must be given and none
+// may contain a dot.
+func (r Route[T]) RoutingKey(params Params) (string, error) {
+	words := strings.Split(r.Key, ".")
+	for i, w := range words {
+		name, ok := paramName(w)
+		if !ok {
+			continue
+		}
+		v, ok := params[name]
+		if !ok || v == "" {
+			return "", fmt.Errorf("routing: %s needs parameter %s", r.Key, name)
+		}
+		if strings.ContainsAny(v, ".*#") {
+			return "", fmt.Errorf("routing: parameter %s=%q can't be used in a routing key", name, v)
+		}
+		words[i] = v
+	}
+	return strings.Join(words, "."), nil
+}
+
+// Pattern is the binding key that matches every routing key of the route.
+// It fails for a route with parameters on anything but a topic exchange.
+func (r Route[T]) Pattern() (string, error) {
+	words := strings.Split(r.Key, ".")
+	for i, w := range words {
+		name, ok := paramName(w)
+		if !ok {
+			continue
+		}
+		if r.Kind != pubsub.ExchangeTopic {
+			return "", fmt.Errorf("routing: %s has parameter %s but %s is a %s exchange", r.Key, name, r.Exchange, r.Kind)
+		}
+		words[i] = "*"
+	}
+	return strings.Join(words, "."), nil
+}
+
+// mustPattern is Pattern for the routes declared in this package.
+func (r Route[T]) mustPattern() string {
+	pattern, err := r.Pattern()
+	if err != nil {
+		panic(err)
+	}
+	return pattern
+}
+
+// Parse extracts the parameters from a routing key of the route.
+func (r Route[T]) Parse(key string) (Params, error) {
+	params, ok := parseKey(r.Key, key)
+	if !ok {
+		return nil, fmt.Errorf("routing: %q doesn't match %s", key, r.Key)
+	}
+	return params, nil
+}
+
+func parseKey(template, key string) (Params, bool) {
+	words := strings.Split(template, ".")
+	got := strings.Split(key, ".")
+	if len(words) != len(got) {
+		return nil, false
+	}
+	params := Params{}
+	for i, w := range words {
+		if name, ok := paramName(w); ok {
+			params[name] = got[i]
+		} else if w != got[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func paramName(word string) (string, bool) {
+	if len(word) > 2 && word[0] == '{' && word[len(word)-1] == '}' {
+		return word[1 : len(word)-1], true
+	}
+	return "", false
+}
+
+// Publish encodes val with the route's codec and publishes it under the
+// routing key built from params.
+func Publish[T any](ctx context.Context, p pubsub.Publisher, r Route[T], params Params, val T, opts ...pubsub.PublishOption) error {
+	key, err := r.RoutingKey(params)
+	if err != nil {
+		return err
+	}
+	return pubsub.Publish(ctx, p, r.Codec, r.Exchange, key, val, opts...)
+}
+
+// Subscribe binds queueName to every routing key of the route and hands the
+// decoded payloads to handler.
+func Subscribe[T any](
+	ctx context.Context,
+	b pubsub.Broker,
+	r Route[T],
+	queueName string,
+	queueType pubsub.SimpleQueueType,
+	handler pubsub.Handler[T],
+	opts ...pubsub.SubscribeOption[T],
+) (*pubsub.Subscription, error) {
+	pattern, err := r.Pattern()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.Subscribe(ctx, b, r.Exchange, queueName, pattern, queueType, handler, opts...)
+}
+
+// RouteInfo describes a registered route without its payload type, for
+// tools like cmd/dlq that handle messages from any route.
+type RouteInfo struct {
+	Name     string
+	Exchange string
+	Key      string
+	// New returns a pointer to a zero payload to decode into.
+	New func() any
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []RouteInfo
+)
+
+// Register adds r to the registry under name and returns it unchanged, so
+// routes can be declared and registered in one go.
+func Register[T any](name string, r Route[T]) Route[T] {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, RouteInfo{
+		Name:     name,
+		Exchange: r.Exchange,
+		Key:      r.Key,
+		New:      func() any { return new(T) },
+	})
+	return r
+}
+
+// Lookup finds the registered route a message published to exchange with
+// key belongs to.
+func Lookup(exchange, key string) (RouteInfo, Params, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, info := range registry {
+		if info.Exchange != exchange {
+			continue
+		}
+		if params, ok := parseKey(info.Key, key); ok {
+			return info, params, true
+		}
+	}
+	return RouteInfo{}, nil, false
+}
+
+// Routes lists every registered route in registration order.
+func Routes() []RouteInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]RouteInfo(nil), registry...)
+}
+
+// RPC is a route that is called rather than published to: each Req sent
+// on it is answered with a Resp. Both are encoded with the route's codec.
+type RPC[Req, Resp any] struct {
+	Route[Req]
+}
+
+// Call sends req on the route and waits for the reply. See pubsub.Call.
+func Call[Req, Resp any](ctx context.Context, b pubsub.Broker, r RPC[Req, Resp], params Params, req Req, opts ...pubsub.PublishOption) (Resp, error) {
+	var resp Resp
+	key, err := r.RoutingKey(params)
+	if err != nil {
+		return resp, err
+	}
+	return pubsub.Call[Req, Resp](ctx, b, r.Codec, r.Exchange, key, req, opts...)
+}
+
+// Serve answers calls made on the route. See pubsub.Serve.
+func Serve[Req, Resp any](
+	ctx context.Context,
+	b pubsub.Broker,
+	r RPC[Req, Resp],
+	queueName string,
+	queueType pubsub.SimpleQueueType,
+	handler func(pubsub.Delivery[Req]) (Resp, error),
+	opts ...pubsub.SubscribeOption[Req],
+) (*pubsub.Subscription, error) {
+	pattern, err := r.Pattern()
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.Serve(ctx, b, r.Exchange, queueName, pattern, queueType, handler, opts...)
+}
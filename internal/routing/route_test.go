@@ -0,0 +1,242 @@
+package routing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+type testMove struct {
+	Player string
+	To     string
+}
+
+var testMoves = Register("test_moves", Route[testMove]{
+	Exchange: "test_topic",
+	Kind:     pubsub.ExchangeTopic,
+	Key:      "moves.{username}.{to}",
+	Codec:    pubsub.Gob,
+})
+
+func TestRoutingKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		params  Params
+		want    string
+		wantErr bool
+	}{
+		{name: "no params", key: "pause", want: "pause"},
+		{name: "extra params", key: "pause", params: Params{"username": "alice"}, want: "pause"},
+		{name: "filled in", key: "moves.{username}.{to}", params: Params{"username": "alice", "to": "europe"}, want: "moves.alice.europe"},
+		{name: "missing", key: "moves.{username}.{to}", params: Params{"username": "alice"}, wantErr: true},
+		{name: "empty", key: "moves.{username}", params: Params{"username": ""}, wantErr: true},
+		{name: "dot", key: "moves.{username}", params: Params{"username": "a.b"}, wantErr: true},
+		{name: "wildcard", key: "moves.{username}", params: Params{"username": "*"}, wantErr: true},
+		{name: "braces alone", key: "moves.{}", want: "moves.{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Route[testMove]{Key: tt.key}.RoutingKey(tt.params)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("RoutingKey = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("RoutingKey = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		kind    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{kind: pubsub.ExchangeTopic, key: "pause", want: "pause"},
+		{kind: pubsub.ExchangeTopic, key: "moves.{username}", want: "moves.*"},
+		{kind: pubsub.ExchangeTopic, key: "moves.{username}.{to}", want: "moves.*.*"},
+		{kind: pubsub.ExchangeTopic, key: "{turn}.resolved", want: "*.resolved"},
+		{kind: pubsub.ExchangeDirect, key: "pause", want: "pause"},
+		{kind: pubsub.ExchangeDirect, key: "moves.{username}", wantErr: true},
+		{kind: pubsub.ExchangeFanout, key: "moves.{username}", wantErr: true},
+		{key: "moves.{username}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Route[testMove]{Kind: tt.kind, Key: tt.key}.Pattern()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Pattern(%q) on a %q exchange = %q, want an error", tt.key, tt.kind, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Pattern(%q) on a %q exchange = %q, %v; want %q", tt.key, tt.kind, got, err, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    Params
+		wantErr bool
+	}{
+		{key: "moves.alice.europe", want: Params{"username": "alice", "to": "europe"}},
+		{key: "moves.alice", wantErr: true},
+		{key: "moves.alice.europe.asia", wantErr: true},
+		{key: "wars.alice.europe", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := testMoves.Parse(tt.key)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %v, %v; want %v", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		exchange string
+		key      string
+		route    string
+		params   Params
+	}{
+		{name: "test route", exchange: "test_topic", key: "moves.alice.europe", route: "test_moves", params: Params{"username": "alice", "to": "europe"}},
+		{name: "game logs", exchange: ExchangePerilTopic, key: GameLogSlug + ".bob", route: "game_logs", params: Params{"username": "bob"}},
+		{name: "wrong exchange", exchange: ExchangePerilTopic, key: "moves.alice.europe"},
+		{name: "unknown key", exchange: "test_topic", key: "moves.alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, params, ok := Lookup(tt.exchange, tt.key)
+			if tt.route == "" {
+				if ok {
+					t.Fatalf("Lookup found %s", info.Name)
+				}
+				return
+			}
+			if !ok || info.Name != tt.route || !reflect.DeepEqual(params, tt.params) {
+				t.Fatalf("Lookup = %s, %v, %v; want %s, %v", info.Name, params, ok, tt.route, tt.params)
+			}
+		})
+	}
+
+	info, _, _ := Lookup("test_topic", "moves.alice.europe")
+	if _, ok := info.New().(*testMove); !ok {
+		t.Fatalf("New() = %T, want *testMove", info.New())
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	seen := map[string]bool{}
+	for _, info := range Routes() {
+		if seen[info.Name] {
+			t.Errorf("route %s is registered twice", info.Name)
+		}
+		seen[info.Name] = true
+	}
+	if !seen["test_moves"] || !seen["game_logs"] {
+		t.Fatalf("Routes() = %v", Routes())
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := pubsub.NewMemoryServer().Connect()
+	defer b.Close()
+	if err := b.DeclareExchange(testMoves.Exchange, pubsub.ExchangeTopic, true); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan pubsub.Delivery[testMove], 1)
+	sub, err := Subscribe(context.Background(), b, testMoves, "test_moves", pubsub.Transient,
+		func(d pubsub.Delivery[testMove]) pubsub.SimpleAckType {
+			got <- d
+			return pubsub.Ack
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	move := testMove{Player: "alice", To: "europe"}
+	if err := Publish(context.Background(), b, testMoves, Params{"username": "alice"}, move); err == nil {
+		t.Fatal("published without every parameter")
+	}
+	if err := Publish(context.Background(), b, testMoves, Params{"username": "alice", "to": "europe"}, move); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-got:
+		if d.Value != move || d.ContentType != pubsub.Gob.ContentType() || d.RoutingKey != "moves.alice.europe" {
+			t.Fatalf("got %+v", d)
+		}
+		params, err := testMoves.Parse(d.RoutingKey)
+		if err != nil || params["to"] != "europe" {
+			t.Fatalf("Parse(%q) = %v, %v", d.RoutingKey, params, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+	}
+}
+
+func TestSubscribeRejectsParamsOnDirectExchange(t *testing.T) {
+	b := pubsub.NewMemoryServer().Connect()
+	defer b.Close()
+	r := Route[testMove]{Exchange: "test_direct", Kind: pubsub.ExchangeDirect, Key: "moves.{username}", Codec: pubsub.JSON}
+	if err := b.DeclareExchange(r.Exchange, r.Kind, true); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Subscribe(context.Background(), b, r, "test_moves", pubsub.Transient,
+		func(pubsub.Delivery[testMove]) pubsub.SimpleAckType { return pubsub.Ack })
+	if err == nil {
+		t.Fatal("subscribed with a wildcard on a direct exchange")
+	}
+}
+
+func TestCallServe(t *testing.T) {
+	b := pubsub.NewMemoryServer().Connect()
+	defer b.Close()
+	echo := RPC[testMove, testMove]{Route[testMove]{
+		Exchange: "test_direct",
+		Kind:     pubsub.ExchangeDirect,
+		Key:      "test.echo",
+		Codec:    pubsub.Gob,
+	}}
+	if err := b.DeclareExchange(echo.Exchange, echo.Kind, true); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Serve(context.Background(), b, echo, "test_echo", pubsub.Transient,
+		func(d pubsub.Delivery[testMove]) (testMove, error) {
+			if d.ContentType != pubsub.Gob.ContentType() {
+				t.Errorf("request content type = %q", d.ContentType)
+			}
+			return testMove{Player: d.Value.Player, To: "asia"}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	resp, err := Call(context.Background(), b, echo, nil, testMove{Player: "alice", To: "europe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != (testMove{Player: "alice", To: "asia"}) {
+		t.Fatalf("Call = %+v", resp)
+	}
+}
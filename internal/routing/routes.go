@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+var (
+	// Turns carries what happened in each turn once the server has resolved
+	// it, keyed by turn number.
+	Turns = Register("turns", Route[gamelogic.TurnResolved]{
+		Exchange: ExchangePerilTopic,
+		Kind:     pubsub.ExchangeTopic,
+		Key:      TurnsPrefix + ".{turn}",
+		Codec:    pubsub.JSON,
+	})
+	// Pause carries the game clock: on pause, on resume and at the start of
+	// every turn.
+	Pause = Register("pause", Route[PlayingState]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      PauseKey,
+		Codec:    pubsub.JSON,
+	})
+	// GameOver announces how the game ended.
+	GameOver = Register("game_over", Route[gamelogic.GameOver]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      GameOverKey,
+		Codec:    pubsub.JSON,
+	})
+	GameLogs = Register("game_logs", Route[GameLog]{
+		Exchange: ExchangePerilTopic,
+		Kind:     pubsub.ExchangeTopic,
+		Key:      GameLogSlug + ".{username}",
+		Codec:    pubsub.Gob,
+	})
+	PlayingStateRPC = RPC[PlayingStateRequest, PlayingState]{Register("playing_state", Route[PlayingStateRequest]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      PlayingStateRPCKey,
+		Codec:    pubsub.JSON,
+	})}
+	Join = RPC[gamelogic.JoinRequest, gamelogic.JoinResponse]{Register("join", Route[gamelogic.JoinRequest]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      JoinRPCKey,
+		Codec:    pubsub.JSON,
+	})}
+	SpawnOrders = RPC[gamelogic.SpawnOrder, gamelogic.OrderAck]{Register("spawn_orders", Route[gamelogic.SpawnOrder]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      SpawnOrderKey,
+		Codec:    pubsub.JSON,
+	})}
+	MoveOrders = RPC[gamelogic.MoveOrder, gamelogic.OrderAck]{Register("move_orders", Route[gamelogic.MoveOrder]{
+		Exchange: ExchangePerilDirect,
+		Kind:     pubsub.ExchangeDirect,
+		Key:      MoveOrderKey,
+		Codec:    pubsub.JSON,
+	})}
+)
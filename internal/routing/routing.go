@@ -1,16 +1,29 @@
 package routing
 
 const (
-	ArmyMovesPrefix = "army_moves"
-
-	WarRecognitionsPrefix = "war"
+	TurnsPrefix = "turns"
 
 	PauseKey = "pause"
 
+	GameOverKey = "game_over"
+
 	GameLogSlug = "game_logs"
+
+	// PlayingStateRPCKey is both the routing key and the server's queue for
+	// clients asking where the game clock is.
+	PlayingStateRPCKey = "rpc.playing_state"
+
+	// Clients join and send their orders to the server over RPC. These
+	// keys double as the server's queue names.
+	JoinRPCKey    = "rpc.join"
+	SpawnOrderKey = "orders.spawn"
+	MoveOrderKey  = "orders.move"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
 )
+
+const QueuePerilDLQ = "peril_dlq"
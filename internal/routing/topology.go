@@ -0,0 +1,85 @@
+package routing
+
+import (
+	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+const (
+	QueueGameLogs     = GameLogSlug
+	QueuePlayingState = PlayingStateRPCKey
+	QueueJoin         = JoinRPCKey
+	QueueSpawnOrders  = SpawnOrderKey
+	QueueMoveOrders   = MoveOrderKey
+)
+
+// TurnsQueue, PauseQueue and GameOverQueue name the queues each player
+// consumes from. They are transient, so they aren't part of Topology;
+// subscribing declares them.
+func TurnsQueue(username string) string {
+	return TurnsPrefix + "." + username
+}
+
+func PauseQueue(username string) string {
+	return PauseKey + "." + username
+}
+
+func GameOverQueue(username string) string {
+	return GameOverKey + "." + username
+}
+
+const clientAppPrefix = "peril_client."
+
+// ClientAppID is the pubsub.AppID a player's client publishes under. The
+// server only takes orders for a player from messages stamped with it,
+// which keeps honest clients consistent but proves nothing: publishers set
+// the AppID themselves.
+func ClientAppID(username string) string {
+	return clientAppPrefix + username
+}
+
+// Sender returns the player whose client published a message, from its
+// AppID.
+func Sender(appID string) (string, bool) {
+	username, ok := strings.CutPrefix(appID, clientAppPrefix)
+	return username, ok && username != ""
+}
+
+// deadLettered are the arguments pubsub.DeclareAndBind gives every queue it
+// declares. Declaring a queue again with different arguments fails.
+var deadLettered = pubsub.Table{"x-dead-letter-exchange": pubsub.DeadLetterExchange}
+
+// Topology is everything shared between Peril processes: the exchanges,
+// the dead-letter queue and the durable queues the server and clients
+// share.
+var Topology = pubsub.Topology{
+	Exchanges: []pubsub.ExchangeSpec{
+		{Name: ExchangePerilDirect, Kind: pubsub.ExchangeDirect, Durable: true},
+		{Name: ExchangePerilTopic, Kind: pubsub.ExchangeTopic, Durable: true},
+		{Name: ExchangePerilDLX, Kind: pubsub.ExchangeFanout, Durable: true},
+	},
+	Queues: []pubsub.QueueSpec{
+		// The DLQ must not dead-letter into itself.
+		{Name: QueuePerilDLQ, Durable: true},
+		{Name: QueueGameLogs, Durable: true, Arguments: deadLettered},
+		{Name: QueuePlayingState, Durable: true, Arguments: deadLettered},
+		{Name: QueueJoin, Durable: true, Arguments: deadLettered},
+		{Name: QueueSpawnOrders, Durable: true, Arguments: deadLettered},
+		{Name: QueueMoveOrders, Durable: true, Arguments: deadLettered},
+	},
+	Bindings: []pubsub.BindingSpec{
+		{Exchange: ExchangePerilDLX, Queue: QueuePerilDLQ, Key: ""},
+		{Exchange: GameLogs.Exchange, Queue: QueueGameLogs, Key: GameLogs.mustPattern()},
+		{Exchange: PlayingStateRPC.Exchange, Queue: QueuePlayingState, Key: PlayingStateRPC.mustPattern()},
+		{Exchange: Join.Exchange, Queue: QueueJoin, Key: Join.mustPattern()},
+		{Exchange: SpawnOrders.Exchange, Queue: QueueSpawnOrders, Key: SpawnOrders.mustPattern()},
+		{Exchange: MoveOrders.Exchange, Queue: QueueMoveOrders, Key: MoveOrders.mustPattern()},
+	},
+}
+
+// DeclareTopology applies Topology. It is safe to call from every process
+// on startup.
+func DeclareTopology(b pubsub.Broker) error {
+	return Topology.Apply(b)
+}